@@ -0,0 +1,221 @@
+package mockconn
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Tolerances of the simulator accuracy, a run fails if the achieved value is farther from the ConnConfig target.
+const (
+	// relative error of the link rate, packets sent per second against Throughput
+	throughputTolerance = 0.05
+	// error of the mean one-way latency: timers fire up to about a millisecond late, plus a relative slack
+	latencyTolerance    = 2 * time.Millisecond
+	latencyRelTolerance = 0.05
+	// error of the loss rate in standard deviations of the binomial distribution, plus an absolute slack
+	lossSigmas   = 4
+	lossAbsSlack = 0.01
+)
+
+// The values achieved by a connection against its config.
+type accuracy struct {
+	conf       ConnConfig
+	sent       int
+	received   int
+	throughput float64       // packets per second passing the link
+	latency    time.Duration // mean one-way latency of received packets
+	loss       float64       // rate of packets lost
+}
+
+// measureAccuracy writes nPacket packets as fast as the link takes them and reads them at the other end.
+func measureAccuracy(conf *ConnConfig, nPacket int) (*accuracy, error) {
+	uc, err := NewUniConn(conf)
+	if err != nil {
+		return nil, err
+	}
+	defer uc.Close()
+
+	sendTime := make([]time.Time, nPacket)
+	recvTime := make([]time.Time, nPacket)
+	writeErr := make(chan error, 1)
+	go func() {
+		b := make([]byte, 1024)
+		for i := 0; i < nPacket; i++ {
+			b[0], b[1], b[2] = byte(i>>16), byte(i>>8), byte(i)
+			if _, err := uc.Write(b); err != nil {
+				writeErr <- err
+				return
+			}
+			sendTime[i] = time.Now()
+		}
+		uc.CloseWrite()
+		writeErr <- nil
+	}()
+
+	b := make([]byte, 1024)
+	received := 0
+	for {
+		if _, err := uc.Read(b); err != nil {
+			break
+		}
+		i := int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+		recvTime[i] = time.Now()
+		received++
+	}
+	if err := <-writeErr; err != nil {
+		return nil, err
+	}
+
+	a := &accuracy{conf: *conf, sent: nPacket, received: received}
+	if span := sendTime[nPacket-1].Sub(sendTime[0]); span > 0 {
+		a.throughput = float64(nPacket-1) / span.Seconds()
+	}
+	var sum time.Duration
+	for i := range recvTime {
+		if !recvTime[i].IsZero() {
+			sum += recvTime[i].Sub(sendTime[i])
+		}
+	}
+	if received > 0 {
+		a.latency = sum / time.Duration(received)
+	}
+	a.loss = 1 - float64(received)/float64(nPacket)
+
+	return a, nil
+}
+
+func (a *accuracy) throughputErr() float64 {
+	return math.Abs(a.throughput-float64(a.conf.Throughput)) / float64(a.conf.Throughput)
+}
+
+func (a *accuracy) latencyErr() time.Duration {
+	d := a.latency - a.conf.Latency
+	if d < 0 {
+		d = -d
+	}
+	return d
+}
+
+func (a *accuracy) lossErr() float64 {
+	return math.Abs(a.loss - float64(a.conf.Loss))
+}
+
+// check returns an error if any value is out of its tolerance.
+func (a *accuracy) check() error {
+	if a.throughputErr() > throughputTolerance {
+		return fmt.Errorf("throughput %.1f is off target %v by more than %v", a.throughput, a.conf.Throughput, throughputTolerance)
+	}
+	if maxErr := latencyTolerance + time.Duration(latencyRelTolerance*float64(a.conf.Latency)); a.latencyErr() > maxErr {
+		return fmt.Errorf("latency %v is off target %v by more than %v", a.latency, a.conf.Latency, maxErr)
+	}
+	p := float64(a.conf.Loss)
+	if maxErr := lossSigmas*math.Sqrt(p*(1-p)/float64(a.sent)) + lossAbsSlack; a.lossErr() > maxErr {
+		return fmt.Errorf("loss %.3f is off target %v by more than %.3f", a.loss, a.conf.Loss, maxErr)
+	}
+	return nil
+}
+
+func (a *accuracy) String() string {
+	return fmt.Sprintf("throughput %v/%.1f pps, latency %v/%v, loss %.3f/%.3f",
+		a.conf.Throughput, a.throughput, a.conf.Latency, a.latency.Round(10*time.Microsecond), a.conf.Loss, a.loss)
+}
+
+// The parameter grid, every case sends one second of packets.
+var accuracyGrid = func() []ConnConfig {
+	var grid []ConnConfig
+	for _, tp := range []uint{100, 500, 1000} {
+		for _, latency := range []time.Duration{10 * time.Millisecond, 50 * time.Millisecond} {
+			for _, loss := range []float32{0, 0.05, 0.2} {
+				grid = append(grid, ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: tp, Latency: latency, Loss: loss})
+			}
+		}
+	}
+	return grid
+}()
+
+// go test -v -run=TestAccuracy
+func TestAccuracy(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip accuracy test in short mode")
+	}
+	// the race detector delays timers and goroutines by several milliseconds under load, which is the simulator
+	// host being slow rather than the simulator being off target, the accuracy is measured without it
+	if raceEnabled {
+		t.Skip("skip accuracy test with the race detector")
+	}
+
+	// cases are mostly waiting for timers, measure them at the same time
+	results := make([]*accuracy, len(accuracyGrid))
+	errs := make([]error, len(accuracyGrid))
+	var wg sync.WaitGroup
+	for i := range accuracyGrid {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = measureAccuracy(&accuracyGrid[i], int(accuracyGrid[i].Throughput))
+		}(i)
+	}
+	wg.Wait()
+
+	for i, conf := range accuracyGrid {
+		a, err := results[i], errs[i]
+		name := fmt.Sprintf("tp=%v/latency=%v/loss=%v", conf.Throughput, conf.Latency, conf.Loss)
+		t.Run(name, func(t *testing.T) {
+			require.Nil(t, err)
+			t.Log(a)
+			require.Nil(t, a.check())
+		})
+	}
+}
+
+// go test -bench=BenchmarkAccuracy -run=^$
+func BenchmarkAccuracy(b *testing.B) {
+	for _, conf := range accuracyGrid {
+		conf := conf
+		name := fmt.Sprintf("tp=%v/latency=%v/loss=%v", conf.Throughput, conf.Latency, conf.Loss)
+		b.Run(name, func(b *testing.B) {
+			var tpErr, latencyErr, lossErr float64
+			for i := 0; i < b.N; i++ {
+				a, err := measureAccuracy(&conf, int(conf.Throughput))
+				require.Nil(b, err)
+				tpErr += a.throughputErr()
+				latencyErr += float64(a.latencyErr())
+				lossErr += a.lossErr()
+			}
+			b.ReportMetric(100*tpErr/float64(b.N), "%tp-err")
+			b.ReportMetric(latencyErr/float64(b.N)/float64(time.Millisecond), "ms-latency-err")
+			b.ReportMetric(100*lossErr/float64(b.N), "%loss-err")
+		})
+	}
+}
+
+// go test -bench=BenchmarkNetConn -run=^$
+func BenchmarkNetConn(b *testing.B) {
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob"}
+	alice, bob, err := NewMockConn(conf)
+	require.Nil(b, err)
+	defer bob.Close()
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			if _, err := bob.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, 1024)
+	b.SetBytes(int64(len(buf)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		alice.Write(buf)
+	}
+	b.StopTimer()
+	alice.Close()
+}
@@ -0,0 +1,270 @@
+package mockconn
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// pcapng block types, option codes and values, see https://www.ietf.org/archive/id/draft-ietf-opsawg-pcapng-01.html
+const (
+	pcapngSectionHeader  = 0x0A0D0D0A
+	pcapngInterfaceDesc  = 0x00000001
+	pcapngEnhancedPacket = 0x00000006
+	pcapngByteOrderMagic = 0x1A2B3C4D
+	pcapngOptEnd         = 0
+	pcapngOptComment     = 1
+	pcapngOptIfName      = 2
+	pcapngOptIfTsresol   = 9
+	pcapngOptEpbFlags    = 2
+	pcapngFlagInbound    = 1
+	pcapngFlagOutbound   = 2
+	linkTypeRaw          = 101 // raw IPv4 or IPv6 packets
+	captureSnapLen       = 65535
+	ipv4HeaderLen        = 20
+	ipv6HeaderLen        = 40
+	udpHeaderLen         = 8
+	ipProtoUDP           = 17
+	tsresolNanosecond    = 9
+)
+
+// Capture writes packets of connections as a pcapng file which Wireshark can open.
+// Packets are wrapped in synthesized IP and UDP headers, the addresses are derived from ClientAddr:
+// an address like "127.0.0.1:8080" is used as it is, other names such as "Alice" are hashed to a 10.0.0.0/8
+// address and a port. Sent and dropped packets are outbound with the drop reason as comment,
+// delivered packets are inbound at the time they are read.
+// Capture is an Observer, add the same Capture to ConnConfig.Observers of connections to capture them into one file.
+type Capture struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+	err error
+}
+
+// NewCapture writes the pcapng section header to w and returns a Capture writing packets to it.
+func NewCapture(w io.Writer) (*Capture, error) {
+	c := &Capture{w: w}
+
+	b := c.block(pcapngSectionHeader)
+	b = appendLE32(b, pcapngByteOrderMagic)
+	b = appendLE16(b, 1) // version 1.0
+	b = appendLE16(b, 0)
+	b = appendLE64(b, 0xFFFFFFFFFFFFFFFF) // section length is not specified
+	c.buf = c.endBlock(b)
+	if _, err := w.Write(c.buf); err != nil {
+		return nil, err
+	}
+
+	b = c.block(pcapngInterfaceDesc)
+	b = appendLE16(b, linkTypeRaw)
+	b = appendLE16(b, 0)
+	b = appendLE32(b, captureSnapLen)
+	b = appendOption(b, pcapngOptIfName, []byte("mockconn"))
+	b = appendOption(b, pcapngOptIfTsresol, []byte{tsresolNanosecond})
+	b = appendOption(b, pcapngOptEnd, nil)
+	c.buf = c.endBlock(b)
+	if _, err := w.Write(c.buf); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Err returns the first error writing packets, packets are not written any more after it.
+func (c *Capture) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// OnEnqueue does nothing, a packet is captured when it is sent or dropped.
+func (c *Capture) OnEnqueue(uc *UniConn, b []byte) {}
+
+// OnSend captures a packet passing the link of uc.
+func (c *Capture) OnSend(uc *UniConn, b []byte) {
+	c.write(uc.localAddr, uc.remoteAddr, b, time.Now(), pcapngFlagOutbound, "")
+}
+
+// OnDrop captures a packet dropped by uc with the reason as comment.
+func (c *Capture) OnDrop(uc *UniConn, b []byte, reason DropReason) {
+	c.write(uc.localAddr, uc.remoteAddr, b, time.Now(), pcapngFlagOutbound, "dropped: "+reason.String())
+}
+
+// OnDeliver captures a packet read from uc.
+func (c *Capture) OnDeliver(uc *UniConn, b []byte, latency time.Duration) {
+	c.write(uc.localAddr, uc.remoteAddr, b, time.Now(), pcapngFlagInbound, "")
+}
+
+// OnClose does nothing, the capture is kept open for other connections.
+func (c *Capture) OnClose(uc *UniConn) {}
+
+func (c *Capture) write(src, dst string, data []byte, t time.Time, flags uint32, comment string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+
+	b := c.block(pcapngEnhancedPacket)
+	b = appendLE32(b, 0) // interface id
+	ts := uint64(t.UnixNano())
+	b = appendLE32(b, uint32(ts>>32))
+	b = appendLE32(b, uint32(ts))
+	lenAt := len(b)
+	b = append(b, make([]byte, 8)...) // captured and original length
+
+	start := len(b)
+	b = appendUDPPacket(b, src, dst, data)
+	n := uint32(len(b) - start)
+	binary.LittleEndian.PutUint32(b[lenAt:], n)
+	binary.LittleEndian.PutUint32(b[lenAt+4:], n)
+	b = pad32(b)
+
+	b = appendOption(b, pcapngOptEpbFlags, appendLE32(nil, flags))
+	if comment != "" {
+		b = appendOption(b, pcapngOptComment, []byte(comment))
+	}
+	b = appendOption(b, pcapngOptEnd, nil)
+	c.buf = c.endBlock(b)
+
+	_, c.err = c.w.Write(c.buf)
+}
+
+// block starts a block in buf, the total length is filled in by endBlock.
+func (c *Capture) block(blockType uint32) []byte {
+	b := appendLE32(c.buf[:0], blockType)
+	return appendLE32(b, 0)
+}
+
+func (c *Capture) endBlock(b []byte) []byte {
+	n := uint32(len(b) + 4)
+	binary.LittleEndian.PutUint32(b[4:], n)
+	return appendLE32(b, n)
+}
+
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = appendLE16(b, code)
+	b = appendLE16(b, uint16(len(value)))
+	b = append(b, value...)
+	return pad32(b)
+}
+
+func appendLE16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func appendLE32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendLE64(b []byte, v uint64) []byte {
+	return appendLE32(appendLE32(b, uint32(v)), uint32(v>>32))
+}
+
+func appendBE16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func pad32(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// captureAddr returns the IP and port of an address, names which are not an IP are hashed into 10.0.0.0/8.
+func captureAddr(addr string) (net.IP, uint16) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, portStr = addr, ""
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(addr))
+	sum := h.Sum32()
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ip = net.IPv4(10, byte(sum>>16), byte(sum>>8), byte(sum))
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		port = 1024 + uint64(sum%(65536-1024))
+	}
+	return ip, uint16(port)
+}
+
+// appendUDPPacket appends an IP packet carrying data in UDP from src to dst, data longer than a UDP datagram is truncated.
+func appendUDPPacket(b []byte, src, dst string, data []byte) []byte {
+	srcIP, srcPort := captureAddr(src)
+	dstIP, dstPort := captureAddr(dst)
+	src4, dst4 := srcIP.To4(), dstIP.To4()
+	ipv4 := src4 != nil && dst4 != nil
+
+	maxData := captureSnapLen - udpHeaderLen - ipv6HeaderLen
+	if ipv4 {
+		maxData = captureSnapLen - udpHeaderLen - ipv4HeaderLen
+	}
+	if len(data) > maxData {
+		data = data[:maxData]
+	}
+	udpLen := udpHeaderLen + len(data)
+
+	var pseudo uint32 // checksum of the pseudo header
+	if ipv4 {
+		b = append(b, 0x45, 0)
+		b = appendBE16(b, uint16(ipv4HeaderLen+udpLen))
+		b = append(b, 0, 0, 0x40, 0, 64, ipProtoUDP, 0, 0) // id, don't fragment, ttl, protocol, checksum
+		b = append(b, src4...)
+		b = append(b, dst4...)
+		ipHeader := b[len(b)-ipv4HeaderLen:]
+		binary.BigEndian.PutUint16(ipHeader[10:], ^fold(checksum(0, ipHeader)))
+		pseudo = checksum(checksum(0, src4), dst4)
+	} else {
+		src16, dst16 := srcIP.To16(), dstIP.To16()
+		b = append(b, 0x60, 0, 0, 0)
+		b = appendBE16(b, uint16(udpLen))
+		b = append(b, ipProtoUDP, 64) // next header, hop limit
+		b = append(b, src16...)
+		b = append(b, dst16...)
+		pseudo = checksum(checksum(0, src16), dst16)
+	}
+	pseudo += ipProtoUDP + uint32(udpLen)
+
+	b = appendBE16(b, srcPort)
+	b = appendBE16(b, dstPort)
+	b = appendBE16(b, uint16(udpLen))
+	b = append(b, 0, 0)
+	b = append(b, data...)
+	udp := b[len(b)-udpLen:]
+	sum := ^fold(checksum(pseudo, udp))
+	if sum == 0 {
+		sum = 0xFFFF
+	}
+	binary.BigEndian.PutUint16(udp[6:], sum)
+
+	return b
+}
+
+// checksum adds b as 16 bits big endian words to sum for the internet checksum.
+func checksum(sum uint32, b []byte) uint32 {
+	for len(b) >= 2 {
+		sum += uint32(b[0])<<8 | uint32(b[1])
+		b = b[2:]
+	}
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+	}
+	return sum
+}
+
+func fold(sum uint32) uint16 {
+	for sum > 0xFFFF {
+		sum = sum>>16 + sum&0xFFFF
+	}
+	return uint16(sum)
+}
@@ -0,0 +1,114 @@
+package mockconn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// A block read back from a pcapng file.
+type pcapngBlock struct {
+	blockType uint32
+	body      []byte
+}
+
+func readPcapng(t *testing.T, b []byte) []pcapngBlock {
+	var blocks []pcapngBlock
+	for len(b) > 0 {
+		require.GreaterOrEqual(t, len(b), 12)
+		n := binary.LittleEndian.Uint32(b[4:])
+		require.Equal(t, uint32(0), n%4)
+		require.Equal(t, n, binary.LittleEndian.Uint32(b[n-4:]))
+		blocks = append(blocks, pcapngBlock{blockType: binary.LittleEndian.Uint32(b), body: b[8 : n-4]})
+		b = b[n:]
+	}
+	return blocks
+}
+
+// go test -v -run=TestCapture
+func TestCapture(t *testing.T) {
+	var buf bytes.Buffer
+	c, err := NewCapture(&buf)
+	require.Nil(t, err)
+
+	conf := &ConnConfig{Addr1: "127.0.0.1:1000", Addr2: "127.0.0.2:2000", Throughput: uint(1000), Latency: time.Millisecond,
+		Loss: 0.5, Observers: []Observer{c}}
+	alice, bob, err := NewMockConn(conf)
+	require.Nil(t, err)
+
+	nPacket := 20
+	go func() {
+		for i := 0; i < nPacket; i++ {
+			alice.Write([]byte{byte(i)})
+		}
+		alice.Close()
+	}()
+	b := make([]byte, 1024)
+	nRead := 0
+	for {
+		if _, err := bob.Read(b); err != nil {
+			break
+		}
+		nRead++
+	}
+	bob.Close()
+	require.Nil(t, c.Err())
+
+	blocks := readPcapng(t, buf.Bytes())
+	require.Equal(t, uint32(pcapngSectionHeader), blocks[0].blockType)
+	require.Equal(t, uint32(pcapngByteOrderMagic), binary.LittleEndian.Uint32(blocks[0].body))
+	require.Equal(t, uint32(pcapngInterfaceDesc), blocks[1].blockType)
+	require.Equal(t, uint16(linkTypeRaw), binary.LittleEndian.Uint16(blocks[1].body))
+
+	var nSent, nDropped, nDelivered int
+	for _, block := range blocks[2:] {
+		require.Equal(t, uint32(pcapngEnhancedPacket), block.blockType)
+		n := binary.LittleEndian.Uint32(block.body[12:])
+		pkt := block.body[20 : 20+n]
+
+		// IPv4 and UDP headers
+		require.Equal(t, uint16(0xFFFF), fold(checksum(0, pkt[:ipv4HeaderLen])))
+		require.Equal(t, net.IPv4(127, 0, 0, 1).To4(), net.IP(pkt[12:16]))
+		require.Equal(t, net.IPv4(127, 0, 0, 2).To4(), net.IP(pkt[16:20]))
+		udp := pkt[ipv4HeaderLen:]
+		require.Equal(t, uint16(1000), binary.BigEndian.Uint16(udp))
+		require.Equal(t, uint16(2000), binary.BigEndian.Uint16(udp[2:]))
+		require.Equal(t, 1, len(udp[udpHeaderLen:]))
+
+		options := block.body[20+(n+3)/4*4:]
+		flags := binary.LittleEndian.Uint32(options[4:])
+		switch {
+		case flags == pcapngFlagInbound:
+			nDelivered++
+		case bytes.Contains(options, []byte("dropped: loss")):
+			nDropped++
+		default:
+			nSent++
+		}
+	}
+	require.Equal(t, nPacket, nSent+nDropped)
+	require.Equal(t, nRead, nSent)
+	require.Equal(t, nRead, nDelivered)
+}
+
+// go test -v -run=TestCaptureAddr
+func TestCaptureAddr(t *testing.T) {
+	ip, port := captureAddr("[::1]:53")
+	require.Equal(t, net.IPv6loopback, ip)
+	require.Equal(t, uint16(53), port)
+
+	ip, port = captureAddr("Alice")
+	ip2, port2 := captureAddr("Alice")
+	require.Equal(t, ip, ip2)
+	require.Equal(t, port, port2)
+	require.Equal(t, byte(10), ip.To4()[0])
+
+	// IPv6 header is used when either endpoint is IPv6
+	pkt := appendUDPPacket(nil, "Alice", "[::1]:53", []byte("hello"))
+	require.Equal(t, byte(0x60), pkt[0])
+	require.Equal(t, ipv6HeaderLen+udpHeaderLen+5, len(pkt))
+}
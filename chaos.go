@@ -0,0 +1,342 @@
+package mockconn
+
+import (
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ChaosKind is a kind of action of Chaos.
+type ChaosKind int
+
+const (
+	ChaosLatencySpike ChaosKind = iota // latency of both directions is raised by SpikeLatency
+	ChaosLossBurst                     // loss rate of both directions is raised to BurstLoss
+	ChaosPause                         // the endpoint is frozen, see NetConn.Freeze
+	ChaosReset                         // the connection is aborted, see NetConn.Kill
+	ChaosPartition                     // packets of both directions are swallowed
+
+	numChaosKinds = iota
+)
+
+func (k ChaosKind) String() string {
+	switch k {
+	case ChaosLatencySpike:
+		return "latency spike"
+	case ChaosLossBurst:
+		return "loss burst"
+	case ChaosPause:
+		return "pause"
+	case ChaosReset:
+		return "reset"
+	case ChaosPartition:
+		return "partition"
+	default:
+		return "unknown"
+	}
+}
+
+// ChaosConfig is the config of Chaos. Rates are the mean number of actions per second, actions of a kind
+// with zero rate are not done.
+type ChaosConfig struct {
+	Seed          int64         // seed of the random actions, a random seed is picked if zero, see Chaos.Seed
+	LatencySpikes float64       // rate of latency spikes
+	LossBursts    float64       // rate of loss bursts
+	Pauses        float64       // rate of pauses
+	Resets        float64       // rate of resets
+	Partitions    float64       // rate of partitions
+	MaxDuration   time.Duration // an action lasts a random time up to MaxDuration, 1s if zero
+	SpikeLatency  time.Duration // latency added by a spike, 200ms if zero
+	BurstLoss     float32       // loss rate of a burst, 0.5 if zero
+	Logger        *slog.Logger  // logs every action, nothing is logged if nil
+}
+
+// ChaosAction is an action done by Chaos.
+type ChaosAction struct {
+	Seq      int           // sequence number of the action since Start
+	Time     time.Duration // time since Start the action is due
+	Kind     ChaosKind
+	Conn     int           // index of the connection in the conns of NewChaos
+	Duration time.Duration // time until the action is undone, zero for resets
+}
+
+// Chaos injects random faults into connections at configured rates. The actions are drawn from a random source
+// of Seed and timed since Start, so a run with the same seed and the same connections in the same order does the
+// same actions at the same times. An action is done on a connection without another action in effect on either
+// endpoint, and it is undone when its duration is over; a connection reset gets no more actions on either endpoint.
+type Chaos struct {
+	conf  ChaosConfig
+	conns []*NetConn
+	rand  *rand.Rand
+
+	mu      sync.Mutex // protect the fields below
+	actions []ChaosAction
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+}
+
+// An action in effect to be undone at time until.
+type chaosUndo struct {
+	until time.Duration
+	conn  int
+	undo  func()
+}
+
+// NewChaos creates a Chaos over endpoints of connections, the actions start after Start.
+func NewChaos(conf ChaosConfig, conns ...*NetConn) *Chaos {
+	if conf.Seed == 0 {
+		conf.Seed = time.Now().UnixNano()
+	}
+	if conf.MaxDuration == 0 {
+		conf.MaxDuration = time.Second
+	}
+	if conf.SpikeLatency == 0 {
+		conf.SpikeLatency = 200 * time.Millisecond
+	}
+	if conf.BurstLoss == 0 {
+		conf.BurstLoss = 0.5
+	}
+	return &Chaos{conf: conf, conns: conns, rand: rand.New(rand.NewSource(conf.Seed))}
+}
+
+// Seed returns the seed of the random actions, set it in ChaosConfig to replay them.
+func (c *Chaos) Seed() int64 {
+	return c.conf.Seed
+}
+
+// Start starts doing actions in a goroutine until Stop. A Chaos can be started only once.
+func (c *Chaos) Start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopCh != nil {
+		return
+	}
+	c.stopCh, c.doneCh = make(chan struct{}), make(chan struct{})
+	c.log("chaos started", "seed", c.conf.Seed, "conns", len(c.conns))
+	go c.run(time.Now())
+}
+
+// Stop stops doing actions and undoes the actions in effect.
+func (c *Chaos) Stop() {
+	c.mu.Lock()
+	stopCh, doneCh := c.stopCh, c.doneCh
+	c.mu.Unlock()
+	if stopCh == nil {
+		return
+	}
+
+	select {
+	case <-stopCh:
+	default:
+		close(stopCh)
+	}
+	<-doneCh
+}
+
+// Actions returns the actions done so far.
+func (c *Chaos) Actions() []ChaosAction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]ChaosAction(nil), c.actions...)
+}
+
+func (c *Chaos) rates() [numChaosKinds]float64 {
+	return [numChaosKinds]float64{c.conf.LatencySpikes, c.conf.LossBursts, c.conf.Pauses, c.conf.Resets, c.conf.Partitions}
+}
+
+// The actions and their undoing are done in the order of their time since start, so the connections an action
+// can choose do not depend on how late the goroutine wakes up. Both endpoints of a connection share its links,
+// so the links an action is done on are busy for the endpoints of either side.
+func (c *Chaos) run(start time.Time) {
+	defer close(c.doneCh)
+
+	var undos []chaosUndo
+	dead := make(map[*UniConn]bool)
+	busy := make(map[*UniConn]bool)
+	defer func() {
+		for _, u := range undos {
+			u.undo()
+		}
+	}()
+
+	rates := c.rates()
+	var total float64
+	for _, r := range rates {
+		total += r
+	}
+	if total <= 0 || len(c.conns) == 0 {
+		<-c.stopCh
+		return
+	}
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	idle := func(nc *NetConn) bool {
+		return !busy[nc.sendConn] && !busy[nc.recvConn] && !dead[nc.sendConn] && !dead[nc.recvConn]
+	}
+
+	next := time.Duration(c.rand.ExpFloat64() / total * float64(time.Second))
+	for seq := 0; ; {
+		// undo the earliest action in effect if it is over before the next action
+		due, undo := next, -1
+		for i, u := range undos {
+			if u.until <= due {
+				due, undo = u.until, i
+			}
+		}
+
+		timer.Reset(time.Until(start.Add(due)))
+		select {
+		case <-timer.C:
+		case <-c.stopCh:
+			return
+		}
+
+		if undo >= 0 {
+			u := undos[undo]
+			undos = append(undos[:undo], undos[undo+1:]...)
+			nc := c.conns[u.conn]
+			busy[nc.sendConn], busy[nc.recvConn] = false, false
+			u.undo()
+			continue
+		}
+
+		// draw the kind and duration even if no connection can take it, so later actions keep their draws
+		kind := chooseKind(c.rand, rates[:], total)
+		duration := time.Duration(c.rand.Int63n(int64(c.conf.MaxDuration)) + 1)
+		target := c.rand.Intn(len(c.conns))
+		next += time.Duration(c.rand.ExpFloat64() / total * float64(time.Second))
+
+		// the target or the first idle connection after it
+		i := target
+		for !idle(c.conns[i]) {
+			if i = (i + 1) % len(c.conns); i == target {
+				break
+			}
+		}
+		if !idle(c.conns[i]) {
+			continue
+		}
+
+		nc := c.conns[i]
+		action := ChaosAction{Seq: seq, Time: due, Kind: kind, Conn: i, Duration: duration}
+		seq++
+		if kind == ChaosReset {
+			// the peer endpoint is reset too
+			action.Duration = 0
+			dead[nc.sendConn], dead[nc.recvConn] = true, true
+			c.do(kind, nc)
+		} else {
+			busy[nc.sendConn], busy[nc.recvConn] = true, true
+			undos = append(undos, chaosUndo{until: due + duration, conn: i, undo: c.do(kind, nc)})
+		}
+
+		c.mu.Lock()
+		c.actions = append(c.actions, action)
+		c.mu.Unlock()
+		c.log("chaos action", "seed", c.conf.Seed, "seq", action.Seq, "time", action.Time,
+			"action", kind.String(), "conn", nc.LocalAddr().String(), "duration", action.Duration)
+	}
+}
+
+// do does an action on nc and returns the function to undo it. Spikes and bursts are kept apart from the
+// latency and loss configured, so undoing them restores the configured values even if they are changed meanwhile.
+func (c *Chaos) do(kind ChaosKind, nc *NetConn) func() {
+	switch kind {
+	case ChaosLatencySpike:
+		nc.spike(c.conf.SpikeLatency)
+		return func() { nc.spike(0) }
+	case ChaosLossBurst:
+		nc.burst(c.conf.BurstLoss)
+		return func() { nc.burst(0) }
+	case ChaosPause:
+		nc.Freeze()
+		return nc.thaw
+	case ChaosReset:
+		nc.Kill()
+		return func() {}
+	case ChaosPartition:
+		nc.partition()
+		return nc.unpartition
+	}
+	return func() {}
+}
+
+// log an event if Logger is set in ChaosConfig.
+func (c *Chaos) log(msg string, args ...any) {
+	if c.conf.Logger != nil {
+		c.conf.Logger.Info(msg, args...)
+	}
+}
+
+// chooseKind returns a kind at random in proportion to the weights of kinds summing up to total.
+func chooseKind(r *rand.Rand, weights []float64, total float64) ChaosKind {
+	x := r.Float64() * total
+	for i, w := range weights {
+		if x < w {
+			return ChaosKind(i)
+		}
+		x -= w
+	}
+	// rounding error, the last kind with weight
+	for i := len(weights) - 1; i > 0; i-- {
+		if weights[i] > 0 {
+			return ChaosKind(i)
+		}
+	}
+	return 0
+}
+
+// thaw undoes a pause, leaving other faults of nc in effect.
+func (nc *NetConn) thaw() {
+	nc.sendConn.setFault(func() { nc.sendConn.writeFrozen = false })
+	nc.recvConn.setFault(func() { nc.recvConn.readFrozen = false })
+}
+
+// partition swallows packets of both directions of nc until unpartition.
+func (nc *NetConn) partition() {
+	nc.sendConn.setFault(func() { nc.sendConn.partitioned = true })
+	nc.recvConn.setFault(func() { nc.recvConn.partitioned = true })
+}
+
+// unpartition undoes partition, a Blackhole of either endpoint stays in effect.
+func (nc *NetConn) unpartition() {
+	nc.sendConn.setFault(func() { nc.sendConn.partitioned = false })
+	nc.recvConn.setFault(func() { nc.recvConn.partitioned = false })
+}
+
+// spike adds latency to both directions of nc until spike(0).
+func (nc *NetConn) spike(latency time.Duration) {
+	nc.sendConn.setFault(func() { nc.sendConn.spikeLatency = latency })
+	nc.recvConn.setFault(func() { nc.recvConn.spikeLatency = latency })
+}
+
+// burst raises the loss rate of both directions of nc to loss until burst(0).
+func (nc *NetConn) burst(loss float32) {
+	nc.sendConn.setFault(func() { nc.sendConn.burstLoss = loss })
+	nc.recvConn.setFault(func() { nc.recvConn.burstLoss = loss })
+}
+
+// lossRate returns the loss rate in effect. The caller should hold uc.mu.
+func (uc *UniConn) lossRate() float32 {
+	if uc.burstLoss > 0 {
+		return uc.burstLoss
+	}
+	return uc.loss
+}
+
+// getLatency returns the latency in effect, including a spike.
+func (uc *UniConn) getLatency() time.Duration {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	return uc.latency + uc.spikeLatency
+}
+
+// getLoss returns the loss rate in effect, including a burst.
+func (uc *UniConn) getLoss() float32 {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	return uc.lossRate()
+}
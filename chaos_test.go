@@ -0,0 +1,143 @@
+package mockconn
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Endpoints of n connections for chaos.
+func chaosConns(t *testing.T, n int) []*NetConn {
+	var conns []*NetConn
+	for i := 0; i < n; i++ {
+		conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(1000), Latency: 10 * time.Millisecond}
+		aliceConn, bobConn, err := NewMockConn(conf)
+		require.Nil(t, err)
+		t.Cleanup(func() {
+			aliceConn.Close()
+			bobConn.Close()
+		})
+		conns = append(conns, aliceConn.(*NetConn))
+	}
+	return conns
+}
+
+// go test -v -run=TestChaos
+func TestChaos(t *testing.T) {
+	conf := ChaosConfig{Seed: 42, LatencySpikes: 20, LossBursts: 20, Pauses: 20, Resets: 5, Partitions: 20,
+		MaxDuration: 50 * time.Millisecond, Logger: testLogger()}
+
+	var runs [2][]ChaosAction
+	for i := range runs {
+		c := NewChaos(conf, chaosConns(t, 4)...)
+		c.Start()
+		time.Sleep(300 * time.Millisecond)
+		c.Stop()
+		runs[i] = c.Actions()
+	}
+
+	// the same seed does the same actions, the runs may stop at a little different time
+	n := len(runs[0])
+	if len(runs[1]) < n {
+		n = len(runs[1])
+	}
+	require.Greater(t, n, 5)
+	require.Equal(t, runs[0][:n], runs[1][:n])
+}
+
+// go test -v -run=TestChaosUndo
+func TestChaosUndo(t *testing.T) {
+	conns := chaosConns(t, 2)
+	conf := ChaosConfig{Seed: 1, LatencySpikes: 100, LossBursts: 100, Partitions: 100, MaxDuration: time.Second,
+		Logger: testLogger()}
+	c := NewChaos(conf, conns...)
+	c.Start()
+	time.Sleep(100 * time.Millisecond)
+	c.Stop()
+	require.NotEmpty(t, c.Actions())
+
+	// actions in effect are undone by Stop
+	for _, nc := range conns {
+		require.Equal(t, 10*time.Millisecond, nc.sendConn.getLatency())
+		require.Equal(t, 10*time.Millisecond, nc.recvConn.getLatency())
+		require.Equal(t, float32(0), nc.sendConn.getLoss())
+		_, err := nc.Write([]byte("hello"))
+		require.Nil(t, err)
+	}
+}
+
+// go test -v -run=TestChaosKeepsFaults
+func TestChaosKeepsFaults(t *testing.T) {
+	conns := chaosConns(t, 1)
+	nc := conns[0]
+	nc.Blackhole()
+	nc.SlowDrain(1000)
+
+	// undoing a pause or a partition leaves the faults injected by the test
+	conf := ChaosConfig{Seed: 1, Pauses: 100, Partitions: 100, MaxDuration: 10 * time.Millisecond,
+		Logger: testLogger()}
+	c := NewChaos(conf, conns...)
+	c.Start()
+	time.Sleep(100 * time.Millisecond)
+	c.Stop()
+	require.NotEmpty(t, c.Actions())
+
+	nc.sendConn.mu.Lock()
+	require.True(t, nc.sendConn.blackhole)
+	require.False(t, nc.sendConn.partitioned)
+	require.False(t, nc.sendConn.writeFrozen)
+	nc.sendConn.mu.Unlock()
+	nc.recvConn.mu.Lock()
+	require.Equal(t, 1000, nc.recvConn.drainRate)
+	require.False(t, nc.recvConn.partitioned)
+	require.False(t, nc.recvConn.readFrozen)
+	nc.recvConn.mu.Unlock()
+}
+
+// go test -v -run=TestChaosBothEndpoints
+func TestChaosBothEndpoints(t *testing.T) {
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(1000), Latency: 10 * time.Millisecond}
+	aliceConn, bobConn, err := NewMockConn(conf)
+	require.Nil(t, err)
+	defer aliceConn.Close()
+	defer bobConn.Close()
+	na, nb := aliceConn.(*NetConn), bobConn.(*NetConn)
+
+	// the endpoints share their links, actions on one of them must not overlap actions on the other
+	chaosConf := ChaosConfig{Seed: 1, LatencySpikes: 200, LossBursts: 200, MaxDuration: 20 * time.Millisecond,
+		Logger: testLogger()}
+	c := NewChaos(chaosConf, na, nb)
+	c.Start()
+	time.Sleep(200 * time.Millisecond)
+	c.Stop()
+
+	actions := c.Actions()
+	require.Greater(t, len(actions), 2)
+	for i := 1; i < len(actions); i++ {
+		require.GreaterOrEqual(t, actions[i].Time, actions[i-1].Time+actions[i-1].Duration)
+	}
+	for _, nc := range []*NetConn{na, nb} {
+		require.Equal(t, 10*time.Millisecond, nc.sendConn.getLatency())
+		require.Equal(t, 10*time.Millisecond, nc.recvConn.getLatency())
+		require.Equal(t, float32(0), nc.sendConn.getLoss())
+		require.Equal(t, float32(0), nc.recvConn.getLoss())
+	}
+}
+
+// go test -v -run=TestChaosResetPeer
+func TestChaosResetPeer(t *testing.T) {
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(1000), Latency: 10 * time.Millisecond}
+	aliceConn, bobConn, err := NewMockConn(conf)
+	require.Nil(t, err)
+	defer aliceConn.Close()
+	defer bobConn.Close()
+
+	// after the first reset neither endpoint gets another action
+	chaosConf := ChaosConfig{Seed: 1, Resets: 100, Logger: testLogger()}
+	c := NewChaos(chaosConf, aliceConn.(*NetConn), bobConn.(*NetConn))
+	c.Start()
+	time.Sleep(100 * time.Millisecond)
+	c.Stop()
+	require.Len(t, c.Actions(), 1)
+}
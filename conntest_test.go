@@ -0,0 +1,25 @@
+package mockconn
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/nettest"
+)
+
+// go test -v -run=TestNetTestConn
+func TestNetTestConn(t *testing.T) {
+	nettest.TestConn(t, func() (c1, c2 net.Conn, stop func(), err error) {
+		conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Latency: time.Millisecond, BufferSize: 1024}
+		c1, c2, err = NewMockConn(conf)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		stop = func() {
+			c1.Close()
+			c2.Close()
+		}
+		return c1, c2, stop, nil
+	})
+}
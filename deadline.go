@@ -0,0 +1,91 @@
+package mockconn
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A deadline which can be changed while Read or Write is waiting on it.
+// Setting a new deadline cancels the old context to wake up the waiters, they should check it again by err.
+type deadline struct {
+	parent context.Context // deadline contexts are canceled when parent is done
+
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newDeadline(parent context.Context) *deadline {
+	d := &deadline{parent: parent}
+	d.set(zeroTime)
+	return d
+}
+
+// set the deadline, zero time means no deadline.
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	cancel := d.cancel
+	if t == zeroTime {
+		d.ctx, d.cancel = context.WithCancel(d.parent)
+	} else {
+		d.ctx, d.cancel = context.WithDeadline(d.parent, t)
+	}
+	d.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// context returns the current deadline context, it is done when deadline is exceeded, parent is done or a new deadline is set.
+func (d *deadline) context() context.Context {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.ctx
+}
+
+// expired returns the error of the current deadline context, nil if the deadline is not exceeded.
+func (d *deadline) expired() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.ctx.Err()
+}
+
+// err returns the error of ctx if it is still the current deadline context, nil if it has been replaced by a new deadline.
+func (d *deadline) err(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if ctx != d.ctx {
+		return nil
+	}
+	return ctx.Err()
+}
+
+// A context for the default timeout of a single read or write, it is never done if timeout is zero.
+func withTimeout(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.Background(), func() {}
+}
+
+// A broadcast signal to wake up all waiters, it should be protected by the lock of its owner.
+type signal struct {
+	ch chan struct{}
+}
+
+// wait returns a channel to be closed by next broadcast.
+func (s *signal) wait() <-chan struct{} {
+	if s.ch == nil {
+		s.ch = make(chan struct{})
+	}
+	return s.ch
+}
+
+func (s *signal) broadcast() {
+	if s.ch != nil {
+		close(s.ch)
+		s.ch = nil
+	}
+}
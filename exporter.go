@@ -0,0 +1,252 @@
+package mockconn
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upper bounds of latency histogram buckets in seconds, the same as the default buckets of Prometheus clients.
+var latencyBuckets = [...]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Exporter is an Observer which counts packets of connections and exports the counters and latency histograms
+// through expvar and in the Prometheus text format. Counters are kept for every connection labeled by the
+// addresses of its endpoints, and in total for the network of all connections observed.
+// Set the same Exporter in ConnConfig of connections to export them together.
+type Exporter struct {
+	network *connStats
+	conns   sync.Map // connKey -> *connStats
+}
+
+type connKey struct {
+	from, to string
+}
+
+// Counters of a connection or the network, accessed atomically.
+type connStats struct {
+	enqueued     int64
+	sent         int64
+	delivered    int64
+	sentBytes    int64
+	deliverBytes int64
+	closed       int64 // directions closed, OnClose is called for each direction of a connection
+	dropped      [numDropReasons]int64
+	latencySum   int64 // nanoseconds
+	latencyCount [len(latencyBuckets) + 1]int64
+}
+
+// NewExporter creates an exporter without counters.
+func NewExporter() *Exporter {
+	return &Exporter{network: &connStats{}}
+}
+
+func (e *Exporter) stats(uc *UniConn) *connStats {
+	key := connKey{from: uc.localAddr, to: uc.remoteAddr}
+	if s, ok := e.conns.Load(key); ok {
+		return s.(*connStats)
+	}
+	s, _ := e.conns.LoadOrStore(key, &connStats{})
+	return s.(*connStats)
+}
+
+// OnEnqueue counts a packet written.
+func (e *Exporter) OnEnqueue(uc *UniConn, b []byte) {
+	for _, s := range []*connStats{e.stats(uc), e.network} {
+		atomic.AddInt64(&s.enqueued, 1)
+	}
+}
+
+// OnSend counts a packet passing the link.
+func (e *Exporter) OnSend(uc *UniConn, b []byte) {
+	for _, s := range []*connStats{e.stats(uc), e.network} {
+		atomic.AddInt64(&s.sent, 1)
+		atomic.AddInt64(&s.sentBytes, int64(len(b)))
+	}
+}
+
+// OnDrop counts a packet dropped by reason.
+func (e *Exporter) OnDrop(uc *UniConn, b []byte, reason DropReason) {
+	if reason < 0 || int(reason) >= len(e.network.dropped) {
+		return
+	}
+	for _, s := range []*connStats{e.stats(uc), e.network} {
+		atomic.AddInt64(&s.dropped[reason], 1)
+	}
+}
+
+// OnDeliver counts a packet read and its latency.
+func (e *Exporter) OnDeliver(uc *UniConn, b []byte, latency time.Duration) {
+	bucket := sort.SearchFloat64s(latencyBuckets[:], latency.Seconds())
+	for _, s := range []*connStats{e.stats(uc), e.network} {
+		atomic.AddInt64(&s.delivered, 1)
+		atomic.AddInt64(&s.deliverBytes, int64(len(b)))
+		atomic.AddInt64(&s.latencySum, int64(latency))
+		atomic.AddInt64(&s.latencyCount[bucket], 1)
+	}
+}
+
+// OnClose counts a direction closed, a connection closed on both ends counts two.
+func (e *Exporter) OnClose(uc *UniConn) {
+	for _, s := range []*connStats{e.stats(uc), e.network} {
+		atomic.AddInt64(&s.closed, 1)
+	}
+}
+
+// A snapshot of counters.
+type statsSnapshot struct {
+	Enqueued       int64            `json:"enqueued"`
+	Sent           int64            `json:"sent"`
+	Delivered      int64            `json:"delivered"`
+	SentBytes      int64            `json:"sentBytes"`
+	DeliveredBytes int64            `json:"deliveredBytes"`
+	Closed         int64            `json:"directionsClosed"`
+	Dropped        map[string]int64 `json:"dropped"`
+	LatencySum     float64          `json:"latencySum"`     // seconds
+	LatencyBuckets []int64          `json:"latencyBuckets"` // cumulative count of latency <= latencyBuckets, the last is +Inf
+}
+
+func (s *connStats) snapshot() *statsSnapshot {
+	snap := &statsSnapshot{
+		Enqueued:       atomic.LoadInt64(&s.enqueued),
+		Sent:           atomic.LoadInt64(&s.sent),
+		Delivered:      atomic.LoadInt64(&s.delivered),
+		SentBytes:      atomic.LoadInt64(&s.sentBytes),
+		DeliveredBytes: atomic.LoadInt64(&s.deliverBytes),
+		Closed:         atomic.LoadInt64(&s.closed),
+		Dropped:        make(map[string]int64),
+		LatencySum:     time.Duration(atomic.LoadInt64(&s.latencySum)).Seconds(),
+	}
+	for i := range s.dropped {
+		snap.Dropped[DropReason(i).String()] = atomic.LoadInt64(&s.dropped[i])
+	}
+	var count int64
+	for i := range s.latencyCount {
+		count += atomic.LoadInt64(&s.latencyCount[i])
+		snap.LatencyBuckets = append(snap.LatencyBuckets, count)
+	}
+	return snap
+}
+
+// The counters of connections sorted by their endpoints.
+func (e *Exporter) connSnapshots() ([]connKey, []*statsSnapshot) {
+	var keys []connKey
+	e.conns.Range(func(k, v interface{}) bool {
+		keys = append(keys, k.(connKey))
+		return true
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].from < keys[j].from || (keys[i].from == keys[j].from && keys[i].to < keys[j].to)
+	})
+
+	snaps := make([]*statsSnapshot, len(keys))
+	for i, k := range keys {
+		s, _ := e.conns.Load(k)
+		snaps[i] = s.(*connStats).snapshot()
+	}
+	return keys, snaps
+}
+
+// Publish exports the counters as an expvar variable of name, like
+// {"network": {...}, "connections": {"Alice->Bob": {...}}}.
+// It panics if the name is already used, like expvar.Publish.
+func (e *Exporter) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		keys, snaps := e.connSnapshots()
+		conns := make(map[string]*statsSnapshot, len(keys))
+		for i, k := range keys {
+			conns[k.from+"->"+k.to] = snaps[i]
+		}
+		return map[string]interface{}{"network": e.network.snapshot(), "connections": conns}
+	}))
+}
+
+// ServeHTTP writes the counters in the Prometheus text exposition format. Metrics of connections are labeled by
+// "from" and "to" endpoint addresses, the mockconn_network_ metrics are the total of all connections.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	e.WritePrometheus(bw)
+	bw.Flush()
+}
+
+// WritePrometheus writes the counters in the Prometheus text exposition format.
+func (e *Exporter) WritePrometheus(w io.Writer) {
+	keys, snaps := e.connSnapshots()
+	labels := make([]string, len(keys))
+	for i, k := range keys {
+		labels[i] = fmt.Sprintf(`from="%s",to="%s"`, escapeLabel(k.from), escapeLabel(k.to))
+	}
+	network := e.network.snapshot()
+
+	for _, prefix := range []string{"mockconn_", "mockconn_network_"} {
+		snaps, labels := snaps, labels
+		if prefix == "mockconn_network_" {
+			snaps, labels = []*statsSnapshot{network}, []string{""}
+		}
+
+		counters := []struct {
+			name, help string
+			value      func(s *statsSnapshot) int64
+		}{
+			{"packets_enqueued_total", "Packets written.", func(s *statsSnapshot) int64 { return s.Enqueued }},
+			{"packets_sent_total", "Packets passed the link.", func(s *statsSnapshot) int64 { return s.Sent }},
+			{"packets_delivered_total", "Packets read.", func(s *statsSnapshot) int64 { return s.Delivered }},
+			{"bytes_sent_total", "Bytes passed the link.", func(s *statsSnapshot) int64 { return s.SentBytes }},
+			{"bytes_delivered_total", "Bytes read.", func(s *statsSnapshot) int64 { return s.DeliveredBytes }},
+			{"directions_closed_total", "Directions of connections closed, a connection has two.", func(s *statsSnapshot) int64 { return s.Closed }},
+		}
+		for _, c := range counters {
+			fmt.Fprintf(w, "# HELP %s%s %s\n# TYPE %s%s counter\n", prefix, c.name, c.help, prefix, c.name)
+			for i, s := range snaps {
+				fmt.Fprintf(w, "%s%s%s %d\n", prefix, c.name, braces(labels[i]), c.value(s))
+			}
+		}
+
+		fmt.Fprintf(w, "# HELP %spackets_dropped_total Packets dropped by reason.\n# TYPE %spackets_dropped_total counter\n", prefix, prefix)
+		for i, s := range snaps {
+			for r := DropLoss; r < numDropReasons; r++ {
+				l := joinLabels(labels[i], fmt.Sprintf(`reason="%s"`, r))
+				fmt.Fprintf(w, "%spackets_dropped_total{%s} %d\n", prefix, l, s.Dropped[r.String()])
+			}
+		}
+
+		fmt.Fprintf(w, "# HELP %slatency_seconds Latency of packets read.\n# TYPE %slatency_seconds histogram\n", prefix, prefix)
+		for i, s := range snaps {
+			for j, count := range s.LatencyBuckets {
+				le := "+Inf"
+				if j < len(latencyBuckets) {
+					le = fmt.Sprint(latencyBuckets[j])
+				}
+				l := joinLabels(labels[i], fmt.Sprintf(`le="%s"`, le))
+				fmt.Fprintf(w, "%slatency_seconds_bucket{%s} %d\n", prefix, l, count)
+			}
+			fmt.Fprintf(w, "%slatency_seconds_sum%s %g\n", prefix, braces(labels[i]), s.LatencySum)
+			fmt.Fprintf(w, "%slatency_seconds_count%s %d\n", prefix, braces(labels[i]), s.LatencyBuckets[len(s.LatencyBuckets)-1])
+		}
+	}
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func joinLabels(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
@@ -0,0 +1,69 @@
+package mockconn
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestExporter
+func TestExporter(t *testing.T) {
+	e := NewExporter()
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(1000), Latency: 20 * time.Millisecond,
+		Observers: []Observer{e}}
+	alice, bob, err := NewMockConn(conf)
+	require.Nil(t, err)
+
+	b := make([]byte, 100)
+	for i := 0; i < 10; i++ {
+		_, err = alice.Write(b)
+		require.Nil(t, err)
+		_, err = bob.Read(b)
+		require.Nil(t, err)
+	}
+	_, err = bob.Write(b)
+	require.Nil(t, err)
+	_, err = alice.Read(b)
+	require.Nil(t, err)
+	alice.Close()
+	bob.Close()
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	text := rec.Body.String()
+	for _, line := range []string{
+		`# TYPE mockconn_packets_sent_total counter`,
+		`mockconn_packets_sent_total{from="Alice",to="Bob"} 10`,
+		`mockconn_packets_sent_total{from="Bob",to="Alice"} 1`,
+		`mockconn_bytes_delivered_total{from="Alice",to="Bob"} 1000`,
+		`mockconn_packets_dropped_total{from="Alice",to="Bob",reason="loss"} 0`,
+		`mockconn_latency_seconds_bucket{from="Alice",to="Bob",le="0.01"} 0`,
+		`mockconn_latency_seconds_bucket{from="Alice",to="Bob",le="+Inf"} 10`,
+		`mockconn_latency_seconds_count{from="Alice",to="Bob"} 10`,
+		`mockconn_network_packets_sent_total 11`,
+		`mockconn_directions_closed_total{from="Alice",to="Bob"} 1`,
+		`mockconn_network_directions_closed_total 2`,
+		`mockconn_network_latency_seconds_count 11`,
+	} {
+		require.Contains(t, text, line+"\n")
+	}
+
+	e.Publish("mockconn_test")
+	var v struct {
+		Network     statsSnapshot
+		Connections map[string]statsSnapshot
+	}
+	require.Nil(t, json.Unmarshal([]byte(expvar.Get("mockconn_test").String()), &v))
+	require.Equal(t, int64(11), v.Network.Delivered)
+	require.Equal(t, int64(10), v.Connections["Alice->Bob"].Delivered)
+	require.Equal(t, int64(0), v.Connections["Bob->Alice"].Dropped["queue"])
+}
+
+// go test -v -run=TestEscapeLabel
+func TestEscapeLabel(t *testing.T) {
+	require.Equal(t, `a\"b\\c\nd`, escapeLabel("a\"b\\c\nd"))
+}
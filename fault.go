@@ -0,0 +1,237 @@
+package mockconn
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// Trigger tells when a fault happens, at a time or when the endpoint has written a number of bytes.
+// A fault without trigger happens at once. A fault at a time is discarded if the peer closes reading before it.
+type Trigger struct {
+	at      time.Time
+	offset  int64 // bytes written by the endpoint
+	byBytes bool
+}
+
+// TriggerAt triggers a fault at time t.
+func TriggerAt(t time.Time) Trigger {
+	return Trigger{at: t}
+}
+
+// TriggerAfter triggers a fault d from now.
+func TriggerAfter(d time.Duration) Trigger {
+	return TriggerAt(time.Now().Add(d))
+}
+
+// TriggerAtByte triggers a fault when the endpoint has written offset bytes in total, it happens after the write
+// reaching the offset returns and affects the calls after it.
+func TriggerAtByte(offset int64) Trigger {
+	return Trigger{offset: offset, byBytes: true}
+}
+
+// A fault waiting for the endpoint to write enough bytes.
+type pendingFault struct {
+	offset int64
+	apply  func()
+}
+
+// Freeze makes the endpoint hang like a stopped process: Write and Read block without progress until Heal,
+// a deadline, a timeout or the context of the call. The peer keeps running, its writes block when the buffer fills.
+func (nc *NetConn) Freeze(triggers ...Trigger) {
+	nc.inject("freeze", func() {
+		nc.sendConn.setFault(func() { nc.sendConn.writeFrozen = true })
+		nc.recvConn.setFault(func() { nc.recvConn.readFrozen = true })
+	}, triggers)
+}
+
+// Blackhole swallows the data written by the endpoint: Write succeeds but nothing arrives at the peer.
+func (nc *NetConn) Blackhole(triggers ...Trigger) {
+	nc.inject("blackhole", func() {
+		nc.sendConn.setFault(func() { nc.sendConn.blackhole = true })
+	}, triggers)
+}
+
+// Kill aborts the connection, both endpoints get ErrConnReset like Reset.
+func (nc *NetConn) Kill(triggers ...Trigger) {
+	nc.inject("kill", func() { nc.Reset() }, triggers)
+}
+
+// SlowDrain makes the endpoint a slow reader which takes at most rate bytes per second from the network, data
+// backs up in flight and then holds back the peer writing. A rate of zero removes the limit.
+func (nc *NetConn) SlowDrain(rate int, triggers ...Trigger) {
+	nc.inject("slow drain", func() {
+		nc.recvConn.setFault(func() { nc.recvConn.drainRate = rate })
+	}, triggers)
+}
+
+// FailNextWrite makes the next Write of the endpoint fail with err without writing anything.
+func (nc *NetConn) FailNextWrite(err error, triggers ...Trigger) {
+	nc.inject("fail next write", func() {
+		nc.faultMu.Lock()
+		nc.failNext = err
+		nc.faultMu.Unlock()
+	}, triggers)
+}
+
+// Vanish makes the endpoint disappear silently like a crashed host or a NAT dropping its mapping. Calls of the
+// endpoint get net.ErrClosed, and closing it does not tell the peer. The peer gets neither io.EOF nor a reset:
+// its reads wait, and its writes succeed until the data nobody reads fills the buffer, then they wait too.
+// Only the deadlines and timeouts of the peer end its calls.
+func (nc *NetConn) Vanish(triggers ...Trigger) {
+	nc.inject("vanish", func() {
+		atomic.StoreInt32(&nc.vanished, 1)
+		nc.sendConn.setFault(func() { nc.sendConn.writerGone = true })
+		nc.sendConn.closeWriteCtxCancel()
+		nc.recvConn.setFault(func() { nc.recvConn.readerGone = true })
+	}, triggers)
+}
+
+// Heal removes the faults of the endpoint in effect except Kill and Vanish, calls blocked by Freeze go on.
+// Faults of the peer and faults triggered later still happen.
+func (nc *NetConn) Heal() {
+	nc.faultMu.Lock()
+	nc.failNext = nil
+	nc.faultMu.Unlock()
+
+	nc.sendConn.setFault(func() {
+		nc.sendConn.writeFrozen = false
+		nc.sendConn.blackhole = false
+	})
+	nc.recvConn.setFault(func() {
+		nc.recvConn.readFrozen = false
+		nc.recvConn.drainRate = 0
+	})
+	nc.sendConn.log(slog.LevelInfo, "faults healed")
+}
+
+// inject applies a fault now or when any of triggers fires.
+func (nc *NetConn) inject(name string, apply func(), triggers []Trigger) {
+	fire := func() {
+		nc.sendConn.log(slog.LevelInfo, "fault injected", "fault", name)
+		apply()
+	}
+	if len(triggers) == 0 {
+		fire()
+		return
+	}
+
+	for _, t := range triggers {
+		if !t.byBytes {
+			// canceled with the events of the link when the peer closes reading, nobody sees the fault then
+			nc.sendConn.mu.Lock()
+			nc.sendConn.schedule(t.at, fire)
+			nc.sendConn.mu.Unlock()
+			continue
+		}
+		nc.faultMu.Lock()
+		reached := nc.written >= t.offset
+		if !reached {
+			nc.pending = append(nc.pending, pendingFault{offset: t.offset, apply: fire})
+		}
+		nc.faultMu.Unlock()
+		if reached {
+			fire()
+		}
+	}
+}
+
+// takeFailure returns the error set by FailNextWrite and clears it.
+func (nc *NetConn) takeFailure() error {
+	nc.faultMu.Lock()
+	defer nc.faultMu.Unlock()
+	err := nc.failNext
+	nc.failNext = nil
+	return err
+}
+
+// wrote counts bytes written and applies the faults triggered by them.
+func (nc *NetConn) wrote(n int) {
+	nc.faultMu.Lock()
+	nc.written += int64(n)
+	var due []func()
+	pending := nc.pending[:0]
+	for _, f := range nc.pending {
+		if nc.written >= f.offset {
+			due = append(due, f.apply)
+		} else {
+			pending = append(pending, f)
+		}
+	}
+	nc.pending = pending
+	nc.faultMu.Unlock()
+
+	for _, apply := range due {
+		apply()
+	}
+}
+
+// setFault changes the fault state by f and wakes up the waiting calls to check it again.
+func (uc *UniConn) setFault(f func()) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	f()
+	uc.readable.broadcast()
+	uc.writable.broadcast()
+}
+
+// waitThawed waits until writing is not frozen.
+func (uc *UniConn) waitThawed(ctx, timeoutCtx context.Context) error {
+	for {
+		deadlineCtx := uc.writeDeadline.context()
+		if err := uc.writeDeadline.err(deadlineCtx); err != nil {
+			return uc.ctxErr(err)
+		}
+
+		uc.mu.Lock()
+		if !uc.writeFrozen {
+			uc.mu.Unlock()
+			return nil
+		}
+		writable := uc.writable.wait()
+		uc.mu.Unlock()
+
+		select {
+		case <-writable: // check again
+
+		case <-uc.closeReadCtx.Done():
+			return ErrConnReset
+
+		case <-deadlineCtx.Done(): // deadline exceeded, write closed or deadline changed, check again
+
+		case <-timeoutCtx.Done():
+			return uc.ctxErr(timeoutCtx.Err())
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// drain takes the time for the reader to take a packet if drainRate is set. The caller should hold uc.mu.
+func (uc *UniConn) drain(dt *dataWithTime, now time.Time) {
+	if uc.drainRate <= 0 {
+		return
+	}
+	if now.Sub(uc.drainNext) >= maxLinkLag {
+		uc.drainNext = now
+	}
+	uc.drainNext = uc.drainNext.Add(time.Duration(len(dt.data)) * time.Second / time.Duration(uc.drainRate))
+}
+
+// Schedule an event to wake up the reader at t if there is none. The caller should hold uc.mu.
+func (uc *UniConn) wakeDrainAt(t time.Time) {
+	if !uc.drainWake {
+		uc.drainWake = true
+		uc.schedule(t, uc.wakeDrainFunc)
+	}
+}
+
+// Event that the reader can take the next packet.
+func (uc *UniConn) wakeDrain() {
+	uc.mu.Lock()
+	uc.drainWake = false
+	uc.readable.broadcast()
+	uc.mu.Unlock()
+}
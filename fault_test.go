@@ -0,0 +1,214 @@
+package mockconn
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestFreeze
+func TestFreeze(t *testing.T) {
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(1000), Latency: 10 * time.Millisecond}
+	aliceConn, bobConn, err := NewMockConn(conf)
+	require.Nil(t, err)
+	alice := aliceConn.(*NetConn)
+
+	_, err = bobConn.Write([]byte("hello"))
+	require.Nil(t, err)
+	alice.Freeze()
+
+	// both write and read of the frozen endpoint hang, even with data arrived
+	b := make([]byte, 1024)
+	alice.SetDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = alice.Write([]byte("hello"))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	_, err = alice.Read(b)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	// blocked calls go on after heal
+	alice.SetDeadline(time.Time{})
+	readCh := make(chan string)
+	go func() {
+		n, _ := alice.Read(b)
+		readCh <- string(b[:n])
+	}()
+	time.Sleep(20 * time.Millisecond)
+	alice.Heal()
+	require.Equal(t, "hello", <-readCh)
+
+	_, err = alice.Write([]byte("world"))
+	require.Nil(t, err)
+	n, err := bobConn.Read(b)
+	require.Nil(t, err)
+	require.Equal(t, "world", string(b[:n]))
+
+	aliceConn.Close()
+	bobConn.Close()
+}
+
+// go test -v -run=TestBlackhole
+func TestBlackhole(t *testing.T) {
+	o := newCountObserver()
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(1000), Latency: 10 * time.Millisecond,
+		Observers: []Observer{o}}
+	aliceConn, bobConn, err := NewMockConn(conf)
+	require.Nil(t, err)
+	aliceConn.(*NetConn).Blackhole()
+
+	for i := 0; i < 10; i++ {
+		_, err = aliceConn.Write([]byte("hello"))
+		require.Nil(t, err)
+	}
+
+	b := make([]byte, 1024)
+	bobConn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = bobConn.Read(b)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.Equal(t, 10, o.drop[DropBlackhole])
+
+	// the other direction still works
+	_, err = bobConn.Write([]byte("hello"))
+	require.Nil(t, err)
+	_, err = aliceConn.Read(b)
+	require.Nil(t, err)
+
+	// Heal of the peer does not remove the blackhole
+	bobConn.(*NetConn).Heal()
+	_, err = aliceConn.Write([]byte("hello"))
+	require.Nil(t, err)
+	bobConn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = bobConn.Read(b)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	aliceConn.Close()
+	bobConn.Close()
+}
+
+// go test -v -run=TestKill
+func TestKill(t *testing.T) {
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(1000), Latency: 10 * time.Millisecond}
+	aliceConn, bobConn, err := NewMockConn(conf)
+	require.Nil(t, err)
+
+	start := time.Now()
+	aliceConn.(*NetConn).Kill(TriggerAfter(50 * time.Millisecond))
+
+	b := make([]byte, 1024)
+	_, err = bobConn.Read(b)
+	require.ErrorIs(t, err, syscall.ECONNRESET)
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	_, err = aliceConn.Read(b)
+	require.ErrorIs(t, err, syscall.ECONNRESET)
+	_, err = bobConn.Write([]byte("hello"))
+	require.ErrorIs(t, err, syscall.ECONNRESET)
+}
+
+// go test -v -run=TestSlowDrain
+func TestSlowDrain(t *testing.T) {
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Latency: 10 * time.Millisecond, BufferSize: 100}
+	aliceConn, bobConn, err := NewMockConn(conf)
+	require.Nil(t, err)
+
+	// Bob takes 100 bytes every 10ms
+	bobConn.(*NetConn).SlowDrain(10000)
+	data := make([]byte, 100)
+	for i := 0; i < 11; i++ {
+		_, err = aliceConn.Write(data)
+		require.Nil(t, err)
+	}
+	aliceConn.Close()
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, bobConn)
+	require.Nil(t, err)
+	require.Equal(t, int64(1100), n)
+	require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	bobConn.Close()
+}
+
+// go test -v -run=TestFailNextWrite
+func TestFailNextWrite(t *testing.T) {
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(1000), Latency: 10 * time.Millisecond}
+	aliceConn, bobConn, err := NewMockConn(conf)
+	require.Nil(t, err)
+
+	// the write after 10 bytes fails, and only that one
+	errBroken := errors.New("broken pipe")
+	aliceConn.(*NetConn).FailNextWrite(errBroken, TriggerAtByte(10))
+	for _, s := range []string{"hello", "world"} {
+		_, err = aliceConn.Write([]byte(s))
+		require.Nil(t, err)
+	}
+	_, err = aliceConn.Write([]byte("lost"))
+	require.ErrorIs(t, err, errBroken)
+	_, err = aliceConn.Write([]byte("again"))
+	require.Nil(t, err)
+
+	aliceConn.Close()
+	got, err := io.ReadAll(bobConn)
+	require.Nil(t, err)
+	require.Equal(t, "helloworldagain", string(got))
+	bobConn.Close()
+}
+
+// go test -v -run=TestFreezeAtByte
+func TestFreezeAtByte(t *testing.T) {
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(1000), Latency: 10 * time.Millisecond}
+	aliceConn, bobConn, err := NewMockConn(conf)
+	require.Nil(t, err)
+
+	aliceConn.(*NetConn).Freeze(TriggerAtByte(5))
+	_, err = aliceConn.Write([]byte("hello"))
+	require.Nil(t, err)
+	aliceConn.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = aliceConn.Write([]byte("world"))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	aliceConn.Close()
+	bobConn.Close()
+}
+
+// go test -v -run=TestVanish
+func TestVanish(t *testing.T) {
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(1000), Latency: 10 * time.Millisecond,
+		BufferSize: 10, WriteTimeout: 100 * time.Millisecond}
+	aliceConn, bobConn, err := NewMockConn(conf)
+	require.Nil(t, err)
+
+	b := make([]byte, 1024)
+	readErr := make(chan error)
+	go func() {
+		_, err := aliceConn.Read(b)
+		readErr <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	aliceConn.(*NetConn).Vanish()
+	require.ErrorIs(t, <-readErr, net.ErrClosed)
+	_, err = aliceConn.Write([]byte("hello"))
+	require.ErrorIs(t, err, net.ErrClosed)
+	require.Nil(t, aliceConn.Close())
+
+	// Bob writes into the void until the buffer is full and the write timeout fires
+	nWrite := 0
+	for {
+		_, err = bobConn.Write([]byte("hello"))
+		if err != nil {
+			break
+		}
+		nWrite++
+	}
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.GreaterOrEqual(t, nWrite, 10)
+
+	// and reads nothing, neither io.EOF
+	bobConn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = bobConn.Read(b)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	bobConn.Close()
+}
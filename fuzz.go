@@ -0,0 +1,129 @@
+package mockconn
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"time"
+)
+
+// Decisions of FuzzInterceptor, the decision of a packet is its first byte modulo numFuzzOps.
+const (
+	fuzzPass    = iota // deliver the packet
+	fuzzDrop           // drop the packet
+	fuzzDelay          // delay the packet by the next byte in fuzzDelayUnit
+	fuzzReorder        // hold the packet and deliver it after the next packet
+	fuzzCorrupt        // xor the byte at the next byte modulo length with the byte after it, or 1
+
+	numFuzzOps = iota
+)
+
+// Unit of delay of a fuzz decision, a byte delays a packet up to 25.5ms.
+const fuzzDelayUnit = 100 * time.Microsecond
+
+// Decisions of a direction.
+type fuzzStream struct {
+	mu   sync.Mutex // protect the fields below, directions are locked separately
+	data []byte     // bytes not used yet
+	held *Packet    // packet held for reordering
+}
+
+// FuzzInterceptor returns an interceptor deciding the fate of every packet by data, such as the input of Go
+// native fuzzing. Bytes at even offsets of data are decisions of the direction from the lesser address, and bytes
+// at odd offsets of the other. The first byte of a packet modulo 5 decides to:
+//
+//	0: pass
+//	1: drop
+//	2: delay it by the next byte times 100µs
+//	3: reorder, it is held and delivered after the next packet, it is lost if there is no next packet
+//	4: corrupt it, the byte at the next byte modulo length is xored with the byte after it, or 1 if that is 0,
+//	   an empty packet passes as it is
+//
+// Packets pass after data is used up. The same data gives the same decisions to the packets of a connection,
+// so an input breaking a protocol replays the same packet schedule. Use a new interceptor for every connection.
+func FuzzInterceptor(data []byte) Interceptor {
+	var streams [2]fuzzStream
+	for i, b := range data {
+		streams[i%2].data = append(streams[i%2].data, b)
+	}
+
+	return func(uc *UniConn, p *Packet, out []*Packet) []*Packet {
+		dir := 0
+		if uc.localAddr > uc.remoteAddr {
+			dir = 1
+		}
+		s := &streams[dir]
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.decide(p, out)
+	}
+}
+
+// NewFuzzConn mocks a connection like NewMockConn with a FuzzInterceptor of data after the interceptors of conf:
+//
+//	f.Fuzz(func(t *testing.T, schedule []byte) {
+//		aliceConn, bobConn, err := NewFuzzConn(conf, schedule)
+//		...
+//	})
+func NewFuzzConn(conf *ConnConfig, data []byte) (net.Conn, net.Conn, error) {
+	fuzzConf := *conf
+	fuzzConf.Interceptors = append(append([]Interceptor(nil), conf.Interceptors...), FuzzInterceptor(data))
+	return NewMockConn(&fuzzConf)
+}
+
+// FuzzSeeds returns schedules of every decision for the seed corpus of a fuzz target, see
+// mockconntest.AddFuzzSeeds. A schedule corrupting every packet is among them, so a target with an interceptor
+// emptying packets before FuzzInterceptor starts with corruptions of empty packets.
+func FuzzSeeds() [][]byte {
+	seeds := [][]byte{{}}
+	for op := byte(0); op < numFuzzOps; op++ {
+		seeds = append(seeds, []byte{op, op, 7, 7, 1, 1, op, op})
+	}
+	return append(seeds, bytes.Repeat([]byte{fuzzCorrupt}, 3*8))
+}
+
+func (s *fuzzStream) next() byte {
+	if len(s.data) == 0 {
+		return 0
+	}
+	b := s.data[0]
+	s.data = s.data[1:]
+	return b
+}
+
+func (s *fuzzStream) decide(p *Packet, out []*Packet) []*Packet {
+	if len(s.data) == 0 {
+		return s.release(append(out, p))
+	}
+
+	switch s.next() % numFuzzOps {
+	case fuzzDrop:
+		return s.release(out)
+	case fuzzDelay:
+		p.Delay += time.Duration(s.next()) * fuzzDelayUnit
+	case fuzzReorder:
+		out = s.release(out)
+		// p.Data is only valid during the call
+		s.held = &Packet{Data: append([]byte(nil), p.Data...), Delay: p.Delay}
+		return out
+	case fuzzCorrupt:
+		// the bytes are used even if an earlier interceptor emptied the packet, so later decisions keep their bytes
+		i, x := int(s.next()), s.next()
+		if x == 0 {
+			x = 1
+		}
+		if len(p.Data) > 0 {
+			p.Data[i%len(p.Data)] ^= x
+		}
+	}
+	return s.release(append(out, p))
+}
+
+// release delivers the packet held after the packets in out.
+func (s *fuzzStream) release(out []*Packet) []*Packet {
+	if s.held != nil {
+		out = append(out, s.held)
+		s.held = nil
+	}
+	return out
+}
@@ -0,0 +1,97 @@
+package mockconn
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestFuzzInterceptor
+func TestFuzzInterceptor(t *testing.T) {
+	// Alice to Bob takes the even bytes: drop "a", hold "b" after "c", corrupt the first byte of "d",
+	// then data is used up and "e" passes
+	schedule := []byte{1, 0, 3, 0, 0, 0, 4, 0, 0, 0, 0xFF}
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", BufferSize: 100}
+	aliceConn, bobConn, err := NewFuzzConn(conf, schedule)
+	require.Nil(t, err)
+
+	for _, s := range []string{"a", "b", "c", "d", "e"} {
+		_, err = aliceConn.Write([]byte(s))
+		require.Nil(t, err)
+	}
+
+	var got []string
+	b := make([]byte, 1024)
+	for i := 0; i < 4; i++ {
+		n, err := bobConn.Read(b)
+		require.Nil(t, err)
+		got = append(got, string(b[:n]))
+	}
+	require.Equal(t, []string{"c", "b", string([]byte{'d' ^ 0xFF}), "e"}, got)
+
+	// Bob to Alice takes the odd bytes, all passed
+	_, err = bobConn.Write([]byte("f"))
+	require.Nil(t, err)
+	n, err := aliceConn.Read(b)
+	require.Nil(t, err)
+	require.Equal(t, "f", string(b[:n]))
+
+	aliceConn.Close()
+	bobConn.Close()
+}
+
+// go test -v -run=TestFuzzEmptyPacket
+func TestFuzzEmptyPacket(t *testing.T) {
+	// an earlier interceptor empties the packets, corruption passes them as they are
+	empty := func(uc *UniConn, p *Packet, out []*Packet) []*Packet {
+		p.Data = p.Data[:0]
+		return append(out, p)
+	}
+	uc, err := NewUniConn(&ConnConfig{Addr1: "Alice", Addr2: "Bob"})
+	require.Nil(t, err)
+	defer uc.Close()
+
+	ic := FuzzInterceptor([]byte{fuzzCorrupt, 0, 3, 0, 7, 0, fuzzCorrupt})
+	for i := 0; i < 2; i++ {
+		p := &Packet{Data: []byte("hello")}
+		empty(uc, p, nil)
+		out := ic(uc, p, nil)
+		require.Len(t, out, 1)
+		require.Empty(t, out[0].Data)
+	}
+}
+
+// go test -fuzz=FuzzSchedule -run=^$
+func FuzzSchedule(f *testing.F) {
+	for _, seed := range FuzzSeeds() {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, schedule []byte) {
+		conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Latency: time.Millisecond, BufferSize: 100}
+		aliceConn, bobConn, err := NewFuzzConn(conf, schedule)
+		require.Nil(t, err)
+
+		nPacket := 10
+		for i := 0; i < nPacket; i++ {
+			_, err = aliceConn.Write([]byte(fmt.Sprintf("packet %d", i)))
+			require.Nil(t, err)
+		}
+		aliceConn.Close()
+
+		// packets may be lost, reordered or corrupted, but nothing else arrives
+		b := make([]byte, 1024)
+		nRead := 0
+		for {
+			n, err := bobConn.Read(b)
+			if err != nil {
+				break
+			}
+			require.Equal(t, len("packet 0"), n)
+			nRead++
+		}
+		require.LessOrEqual(t, nRead, nPacket)
+		bobConn.Close()
+	})
+}
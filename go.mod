@@ -1,9 +1,10 @@
 module github.com/nknorg/mockconn-go
 
-go 1.18
+go 1.21
 
 require (
 	github.com/stretchr/testify v1.8.1
+	golang.org/x/net v0.20.0
 	golang.org/x/time v0.3.0
 )
 
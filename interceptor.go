@@ -0,0 +1,105 @@
+package mockconn
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// Packet is a packet passing the link, interceptors may change it.
+type Packet struct {
+	Data  []byte        // data of the packet, it is only valid during the call of interceptor, copy it to keep it
+	Delay time.Duration // delay on top of Latency, a packet delayed may be read after packets sent later
+
+	drop DropReason // reason if the packet is dropped
+}
+
+// Interceptor sees a packet after it passes the link and before it is in flight to the reader.
+// It appends the packets to deliver in place of p to out and returns the result, like append:
+//
+//	return append(out, p)                                // pass
+//	return out                                           // drop
+//	p.Delay = time.Second; return append(out, p)         // delay
+//	p.Data[0] ^= 1; return append(out, p)                // modify
+//	return append(out, p, &Packet{Data: p.Data})         // duplicate
+//	return append(out, p, &Packet{Data: []byte("evil")}) // inject
+//
+// Interceptors of a connection form a chain, every packet out of one is seen by the next.
+// They are called while the connection is locked, like Observer callbacks.
+type Interceptor func(uc *UniConn, p *Packet, out []*Packet) []*Packet
+
+// RandomLoss drops packets at the rate of loss, they are counted as lost in Metrics.
+// The Loss of a connection is a RandomLoss before other interceptors, it can be changed by SetLoss.
+func RandomLoss(loss float32) Interceptor {
+	return func(uc *UniConn, p *Packet, out []*Packet) []*Packet {
+		return randomLoss(uc, p, loss, out)
+	}
+}
+
+func randomLoss(uc *UniConn, p *Packet, loss float32, out []*Packet) []*Packet {
+	if rand.Float32() < loss {
+		atomic.AddInt64(&uc.nLoss, 1)
+		p.drop = DropLoss
+		return out
+	}
+	return append(out, p)
+}
+
+// intercept passes a packet through interceptors and sends the packets out of them.
+func (uc *UniConn) intercept(dt *dataWithTime, now time.Time) {
+	dt.pkt = Packet{Data: dt.data, drop: DropIntercept}
+	packets := uc.intercepted[0][:0]
+	if loss := uc.lossRate(); loss > 0 {
+		packets = randomLoss(uc, &dt.pkt, loss, packets)
+	} else {
+		packets = append(packets, &dt.pkt)
+	}
+	for _, ic := range uc.interceptors {
+		// packets may be empty if dropped by an earlier interceptor
+		out := uc.intercepted[1][:0]
+		for _, p := range packets {
+			out = ic(uc, p, out)
+		}
+		uc.intercepted[0], uc.intercepted[1] = out, packets
+		packets = out
+	}
+
+	// New packets are copied to pooled buffers before the original packet is released, they may share its data.
+	// The original packet is copied as well if its data is replaced, then it is released without a drop.
+	kept, passed := false, false
+	dts := uc.interceptedDt[:0]
+	for _, p := range packets {
+		if p == &dt.pkt {
+			passed = true
+			if !kept && sameBuffer(p.Data, dt.data) {
+				kept = true
+				dt.data = p.Data
+				dts = append(dts, dt)
+				continue
+			}
+		}
+		d := getPacket(p.Data)
+		d.pkt.Delay = p.Delay
+		dts = append(dts, d)
+	}
+	if !kept {
+		if !passed {
+			uc.onDrop(dt, dt.pkt.drop)
+		}
+		putPacket(dt)
+	}
+
+	for i, d := range dts {
+		uc.send(d, now)
+		dts[i] = nil
+	}
+	for i := range packets {
+		packets[i] = nil
+	}
+	uc.interceptedDt = dts[:0]
+}
+
+// sameBuffer tells if b is still in the buffer of data.
+func sameBuffer(b, data []byte) bool {
+	return len(b) > 0 && cap(b) == cap(data) && &b[:1][0] == &data[:1][0]
+}
@@ -0,0 +1,148 @@
+package mockconn
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestInterceptor
+func TestInterceptor(t *testing.T) {
+	// packet "drop" is dropped, "flip" is modified, "dup" is duplicated, "slow" is delayed,
+	// and "evil" is injected after "inject"
+	ic := func(uc *UniConn, p *Packet, out []*Packet) []*Packet {
+		switch string(p.Data) {
+		case "drop":
+			return out
+		case "flip":
+			p.Data[0] = 'F'
+		case "dup":
+			return append(out, p, &Packet{Data: p.Data})
+		case "slow":
+			p.Delay = 50 * time.Millisecond
+		case "inject":
+			return append(out, p, &Packet{Data: []byte("evil")})
+		}
+		return append(out, p)
+	}
+
+	o := newCountObserver()
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", BufferSize: 100, Latency: 10 * time.Millisecond, Interceptors: []Interceptor{ic},
+		Observers: []Observer{o}}
+	uc, err := NewUniConn(conf)
+	require.Nil(t, err)
+
+	for _, s := range []string{"slow", "drop", "flip", "dup", "inject", "end"} {
+		_, err = uc.Write([]byte(s))
+		require.Nil(t, err)
+	}
+
+	var got []string
+	b := make([]byte, 1024)
+	for i := 0; i < 7; i++ {
+		n, err := uc.Read(b)
+		require.Nil(t, err)
+		got = append(got, string(b[:n]))
+	}
+	require.Equal(t, []string{"Flip", "dup", "dup", "inject", "evil", "end", "slow"}, got)
+	require.Equal(t, 1, o.drop[DropIntercept])
+	uc.Close()
+}
+
+// go test -v -run=TestInterceptorChain
+func TestInterceptorChain(t *testing.T) {
+	double := func(uc *UniConn, p *Packet, out []*Packet) []*Packet {
+		return append(out, p, &Packet{Data: p.Data})
+	}
+	var seen int
+	count := func(uc *UniConn, p *Packet, out []*Packet) []*Packet {
+		seen++
+		return append(out, p)
+	}
+
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Interceptors: []Interceptor{double, double, count}}
+	uc, err := NewUniConn(conf)
+	require.Nil(t, err)
+
+	_, err = uc.Write([]byte("hello"))
+	require.Nil(t, err)
+	require.Equal(t, 4, seen)
+
+	b := make([]byte, 1024)
+	for i := 0; i < 4; i++ {
+		n, err := uc.Read(b)
+		require.Nil(t, err)
+		require.Equal(t, "hello", string(b[:n]))
+	}
+	uc.Close()
+}
+
+// go test -v -run=TestInterceptorChainDrop
+func TestInterceptorChainDrop(t *testing.T) {
+	drop := func(uc *UniConn, p *Packet, out []*Packet) []*Packet {
+		return out
+	}
+	var seen int
+	count := func(uc *UniConn, p *Packet, out []*Packet) []*Packet {
+		seen++
+		return append(out, p)
+	}
+
+	// packets dropped by Loss or an interceptor are not seen by the interceptors after
+	o := newCountObserver()
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Loss: 0.5, Interceptors: []Interceptor{count, drop, count},
+		Observers: []Observer{o}}
+	uc, err := NewUniConn(conf)
+	require.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		_, err = uc.Write([]byte("hello"))
+		require.Nil(t, err)
+	}
+	require.Equal(t, 100-int(uc.Metrics().Loss), seen)
+	require.Equal(t, 100, o.drop[DropLoss]+o.drop[DropIntercept])
+	require.Equal(t, 0, o.send)
+	uc.Close()
+}
+
+// go test -v -run=TestInterceptorReplace
+func TestInterceptorReplace(t *testing.T) {
+	header := func(uc *UniConn, p *Packet, out []*Packet) []*Packet {
+		p.Data = append([]byte("header:"), p.Data...)
+		return append(out, p)
+	}
+
+	// the packet with data replaced is sent, not dropped
+	o := newCountObserver()
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Interceptors: []Interceptor{header}, Observers: []Observer{o}}
+	uc, err := NewUniConn(conf)
+	require.Nil(t, err)
+
+	_, err = uc.Write([]byte("hello"))
+	require.Nil(t, err)
+	b := make([]byte, 1024)
+	n, err := uc.Read(b)
+	require.Nil(t, err)
+	require.Equal(t, "header:hello", string(b[:n]))
+	require.Equal(t, 1, o.send)
+	require.Equal(t, 0, len(o.drop))
+	uc.Close()
+}
+
+// go test -v -run=TestRandomLoss
+func TestRandomLoss(t *testing.T) {
+	o := newCountObserver()
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Interceptors: []Interceptor{RandomLoss(1)}, Observers: []Observer{o}}
+	uc, err := NewUniConn(conf)
+	require.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		_, err = uc.Write([]byte("hello"))
+		require.Nil(t, err)
+	}
+	require.Equal(t, int64(10), uc.Metrics().Loss)
+	require.Equal(t, 10, o.drop[DropLoss])
+	require.Equal(t, 0, o.send)
+	uc.Close()
+}
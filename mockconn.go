@@ -1,6 +1,7 @@
 package mockconn
 
 import (
+	"log/slog"
 	"net"
 	"time"
 )
@@ -11,12 +12,18 @@ import (
 type ConnConfig struct {
 	Addr1        string        // endpoint 1 address
 	Addr2        string        // endpoint 2 address
-	Throughput   uint          // throughput by packets/second
-	BufferSize   uint          // BufferSize used int connection. If it is not set, a default value will be computed.
+	Throughput   uint          // throughput by packets/second, unlimited if zero
+	BufferSize   uint          // packets in flight, 2 * Throughput * Latency if zero, unbounded if Throughput is zero too
 	Latency      time.Duration // Latency is the duration which the packet travels from endpoint 1 to endpoint 2.
-	Loss         float32       // loss rate, 0.01 = 1%
+	Loss         float32       // loss rate, 0.01 = 1%, packets are lost by RandomLoss before other interceptors
 	WriteTimeout time.Duration // set default timeout for writing, without timeout if zero
 	ReadTimeout  time.Duration // set default timeout for reading, without timeout if zero
+	Queue        QueueConfig   // bottleneck queue discipline, without queue writers are held back by the link
+	NonBlocking  bool          // write never waits for the link, packets the link can not take right now are dropped
+	Scheduler    *Scheduler    // scheduler to run timed events, a shared default scheduler is used if it is not set
+	Observers    []Observer    // notified of the fate of every packet
+	Logger       *slog.Logger  // log events of the connection, such as open, close, drops and deadline expirations
+	Interceptors []Interceptor // see packets passing the link, they may drop, delay, modify, duplicate or inject packets
 }
 
 // Mock network connection
@@ -1,9 +1,13 @@
 package mockconn
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"io"
 	"math"
+	"net"
+	"runtime"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -13,7 +17,7 @@ import (
 // go test -v -run=TestBidirection
 func TestBidirection(t *testing.T) {
 	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(256), Latency: 100 * time.Millisecond}
-	log.Printf("Going to test bi-direction communicating, throughput is %v, latency is %v \n",
+	t.Logf("Going to test bi-direction communicating, throughput is %v, latency is %v",
 		conf.Throughput, conf.Latency)
 
 	aliceConn, bobConn, err := NewMockConn(conf)
@@ -26,7 +30,7 @@ func TestBidirection(t *testing.T) {
 	i := 0
 	sendChan := make(chan []int64)
 	recvChan := make(chan []int64)
-	go WritePacktes(aliceConn, nPackets, sendChan)
+	go WritePackets(aliceConn, nPackets, sendChan)
 	go ReadPackets(bobConn, nPackets, conf.Latency, recvChan)
 
 	sendSeq := <-sendChan
@@ -35,18 +39,18 @@ func TestBidirection(t *testing.T) {
 	minLen := int(math.Min(float64(len(sendSeq)), float64(len(recvSeq))))
 	for i = 0; i < minLen; i++ {
 		if sendSeq[i] != recvSeq[i] {
-			log.Printf("%v sendSeq[%v] %v != %v recvSeq[%v] %v \n",
+			t.Logf("%v sendSeq[%v] %v != %v recvSeq[%v] %v",
 				aliceConn.LocalAddr(), i, sendSeq[i], bobConn.LocalAddr(), i, recvSeq[i])
 		}
 	}
 	if i == nPackets {
-		log.Printf("%v write to %v %v packets, %v receive %v packets in the same sequence\n",
+		t.Logf("%v write to %v %v packets, %v receive %v packets in the same sequence",
 			aliceConn.LocalAddr(), bobConn.LocalAddr(), nPackets, bobConn.LocalAddr(), nPackets)
 	}
 
 	// Bob send to Alice
 	nPackets = 50
-	go WritePacktes(bobConn, nPackets, sendChan)
+	go WritePackets(bobConn, nPackets, sendChan)
 	go ReadPackets(aliceConn, nPackets, conf.Latency, recvChan)
 
 	sendSeq = <-sendChan
@@ -55,12 +59,12 @@ func TestBidirection(t *testing.T) {
 	minLen = int(math.Min(float64(len(sendSeq)), float64(len(recvSeq))))
 	for i = 0; i < minLen; i++ {
 		if sendSeq[i] != recvSeq[i] {
-			log.Printf("%v sendSeq[%v] %v != %v recvSeq[%v] %v \n",
+			t.Logf("%v sendSeq[%v] %v != %v recvSeq[%v] %v",
 				bobConn.LocalAddr(), i, sendSeq[i], aliceConn.LocalAddr(), i, recvSeq[i])
 		}
 	}
 	if i == nPackets {
-		log.Printf("%v write to %v %v packets, %v receive %v packets in the same sequence\n",
+		t.Logf("%v write to %v %v packets, %v receive %v packets in the same sequence",
 			bobConn.LocalAddr(), aliceConn.LocalAddr(), nPackets, aliceConn.LocalAddr(), nPackets)
 	}
 }
@@ -71,7 +75,7 @@ func TestNetConnThroughput(t *testing.T) {
 	for i := 1; i <= 4; i++ {
 		tp := tpBase * uint(i)
 		conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: tp, Latency: 20 * time.Millisecond}
-		log.Printf("Going to test throughput at %v packets/s, latency %v\n", conf.Throughput, conf.Latency)
+		t.Logf("Going to test throughput at %v packets/s, latency %v", conf.Throughput, conf.Latency)
 
 		aliceConn, bobConn, err := NewMockConn(conf)
 		require.NotNil(t, aliceConn)
@@ -81,19 +85,18 @@ func TestNetConnThroughput(t *testing.T) {
 		nPackets := 256
 		sendChan := make(chan []int64)
 		recvChan := make(chan []int64)
-		go WritePacktes(aliceConn, nPackets, sendChan)
+		go WritePackets(aliceConn, nPackets, sendChan)
 		go ReadPackets(bobConn, nPackets, conf.Latency, recvChan)
 
 		<-sendChan
 		<-recvChan
-		fmt.Println()
 	}
 }
 
 // go test -v -run=TestHighLatency
 func TestHighLatency(t *testing.T) {
 	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(128), Latency: 500 * time.Millisecond}
-	log.Printf("Going to test throughput at %v packets/s, high latency %v\n", conf.Throughput, conf.Latency)
+	t.Logf("Going to test throughput at %v packets/s, high latency %v", conf.Throughput, conf.Latency)
 
 	aliceConn, bobConn, err := NewMockConn(conf)
 	require.NotNil(t, aliceConn)
@@ -103,7 +106,7 @@ func TestHighLatency(t *testing.T) {
 	nPackets := 256
 	sendChan := make(chan []int64)
 	recvChan := make(chan []int64)
-	go WritePacktes(aliceConn, nPackets, sendChan)
+	go WritePackets(aliceConn, nPackets, sendChan)
 	go ReadPackets(bobConn, nPackets, conf.Latency, recvChan)
 
 	<-sendChan
@@ -113,7 +116,7 @@ func TestHighLatency(t *testing.T) {
 // go test -v -run=TestLoss
 func TestLoss(t *testing.T) {
 	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(128), Latency: 20 * time.Millisecond, Loss: 0.01}
-	log.Printf("Going to test throughput at %v packets/s, latency %v, loss :%v \n", conf.Throughput, conf.Latency, conf.Loss)
+	t.Logf("Going to test throughput at %v packets/s, latency %v, loss :%v", conf.Throughput, conf.Latency, conf.Loss)
 
 	aliceConn, bobConn, err := NewMockConn(conf)
 	require.NotNil(t, aliceConn)
@@ -123,7 +126,7 @@ func TestLoss(t *testing.T) {
 	nPackets := 256
 	sendChan := make(chan []int64)
 	recvChan := make(chan []int64)
-	go WritePacktes(aliceConn, nPackets, sendChan)
+	go WritePackets(aliceConn, nPackets, sendChan)
 	go ReadPackets(bobConn, nPackets, conf.Latency, recvChan)
 
 	<-sendChan
@@ -131,3 +134,199 @@ func TestLoss(t *testing.T) {
 	bobConn.Close()
 	<-recvChan
 }
+
+// go test -v -run=TestCloseEOF
+func TestCloseEOF(t *testing.T) {
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(128), Latency: 50 * time.Millisecond}
+	aliceConn, bobConn, err := NewMockConn(conf)
+	require.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err = aliceConn.Write([]byte("hello"))
+		require.Nil(t, err)
+	}
+	require.Nil(t, aliceConn.Close())
+	require.Nil(t, aliceConn.Close())
+
+	// data in flight is delivered before io.EOF
+	b := make([]byte, 1024)
+	for i := 0; i < 3; i++ {
+		n, err := bobConn.Read(b)
+		require.Nil(t, err)
+		require.Equal(t, "hello", string(b[:n]))
+	}
+	n, err := bobConn.Read(b)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, 0, n)
+	n, err = bobConn.Read(b)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, 0, n)
+	bobConn.Close()
+}
+
+// go test -v -run=TestReset
+func TestReset(t *testing.T) {
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(128), Latency: 50 * time.Millisecond}
+	aliceConn, bobConn, err := NewMockConn(conf)
+	require.Nil(t, err)
+
+	_, err = aliceConn.Write([]byte("hello"))
+	require.Nil(t, err)
+
+	readErr := make(chan error)
+	go func() {
+		b := make([]byte, 1024)
+		_, err := bobConn.Read(b)
+		readErr <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	require.Nil(t, aliceConn.(*NetConn).Reset())
+
+	// data in flight is discarded
+	err = <-readErr
+	require.ErrorIs(t, err, syscall.ECONNRESET)
+
+	_, err = bobConn.Write([]byte("hello"))
+	require.ErrorIs(t, err, syscall.ECONNRESET)
+}
+
+// go test -v -run=TestReadWriteContext
+func TestReadWriteContext(t *testing.T) {
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(128), Latency: 20 * time.Millisecond}
+	aliceConn, bobConn, err := NewMockConn(conf)
+	require.Nil(t, err)
+	alice, bob := aliceConn.(*NetConn), bobConn.(*NetConn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	b := make([]byte, 1024)
+	n, err := bob.ReadContext(ctx, b)
+	require.ErrorIs(t, err, context.Canceled)
+	require.NotErrorIs(t, err, net.ErrClosed)
+	require.Equal(t, 0, n)
+
+	n, err = alice.WriteContext(ctx, []byte("hello"))
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 0, n)
+
+	// connection still works after the context is done
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	n, err = alice.WriteContext(ctx, []byte("hello"))
+	require.Nil(t, err)
+	require.Equal(t, 5, n)
+	n, err = bob.ReadContext(ctx, b)
+	require.Nil(t, err)
+	require.Equal(t, "hello", string(b[:n]))
+
+	alice.Close()
+	bob.Close()
+}
+
+// go test -v -run=TestCloseGoroutines
+func TestCloseGoroutines(t *testing.T) {
+	nGoroutine := runtime.NumGoroutine()
+
+	var conns []net.Conn
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(1000), Latency: 10 * time.Millisecond,
+			Loss: 0.1}
+		aliceConn, bobConn, err := NewMockConn(conf)
+		require.Nil(t, err)
+		conns = append(conns, aliceConn, bobConn)
+
+		// readers blocked when the connection is closed
+		for _, conn := range []net.Conn{aliceConn, bobConn} {
+			wg.Add(1)
+			go func(conn net.Conn) {
+				defer wg.Done()
+				b := make([]byte, 1024)
+				for {
+					if _, err := conn.Read(b); err != nil {
+						return
+					}
+				}
+			}(conn)
+			for j := 0; j < 10; j++ {
+				_, err = conn.Write([]byte("hello"))
+				require.Nil(t, err)
+			}
+		}
+	}
+
+	// Close is idempotent, later operations get net.ErrClosed
+	b := make([]byte, 1024)
+	for _, conn := range conns {
+		require.Nil(t, conn.Close())
+		require.Nil(t, conn.Close())
+		_, err := conn.Write(b)
+		require.ErrorIs(t, err, net.ErrClosed)
+		_, err = conn.Read(b)
+		require.ErrorIs(t, err, net.ErrClosed)
+	}
+	wg.Wait()
+
+	// goroutines of the scheduler exit when it is idle
+	waitGoroutines(t, nGoroutine)
+}
+
+// go test -v -run=TestCloseLongLatency
+func TestCloseLongLatency(t *testing.T) {
+	s := NewScheduler(2)
+	defer s.Close()
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(10), Latency: 5 * time.Second, Scheduler: s}
+	aliceConn, bobConn, err := NewMockConn(conf)
+	require.Nil(t, err)
+
+	// a packet in flight, the link waiting for the next packet and a fault due later
+	for i := 0; i < 2; i++ {
+		_, err = aliceConn.Write([]byte("hello"))
+		require.Nil(t, err)
+	}
+	bobConn.(*NetConn).Freeze(TriggerAfter(5 * time.Second))
+	require.True(t, s.isRunning())
+
+	// their events are discarded by Close, the scheduler goes idle long before they are due
+	aliceConn.Close()
+	bobConn.Close()
+	for deadline := time.Now().Add(time.Second); s.isRunning(); time.Sleep(10 * time.Millisecond) {
+		require.True(t, time.Now().Before(deadline), "scheduler is kept running by closed connections")
+	}
+}
+
+// waitGoroutines waits a second for the number of goroutines to be no more than n.
+func waitGoroutines(t *testing.T, n int) {
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > n; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			require.LessOrEqual(t, runtime.NumGoroutine(), n, "goroutines are left running")
+		}
+	}
+}
+
+// go test -v -run=TestNetConnCloseGracefully
+func TestNetConnCloseGracefully(t *testing.T) {
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(100), Latency: 50 * time.Millisecond}
+	aliceConn, bobConn, err := NewMockConn(conf)
+	require.Nil(t, err)
+
+	for i := 0; i < 5; i++ {
+		_, err = aliceConn.Write([]byte("hello"))
+		require.Nil(t, err)
+	}
+
+	// Alice lingers until Bob reads the data written
+	start := time.Now()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		io.Copy(io.Discard, bobConn)
+	}()
+	require.Nil(t, aliceConn.(*NetConn).CloseGracefully(context.Background()))
+	require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	_, err = aliceConn.Read(make([]byte, 1024))
+	require.ErrorIs(t, err, net.ErrClosed)
+	bobConn.Close()
+}
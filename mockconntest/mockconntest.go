@@ -0,0 +1,256 @@
+// Package mockconntest provides helpers for tests using mockconn: connection pairs closed with the test,
+// goroutine leak checks, seeds of fuzz targets, a traffic generator and assertions on the traffic delivered.
+package mockconntest
+
+import (
+	"encoding/binary"
+	"math"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	mockconn "github.com/nknorg/mockconn-go"
+)
+
+// Header of a packet of traffic: sequence number and the time it is written in unix nanoseconds.
+const headerLen = 16
+
+// NewPair mocks a connection of conf, both endpoints are closed when the test and its subtests finish.
+func NewPair(tb testing.TB, conf *mockconn.ConnConfig) (*mockconn.NetConn, *mockconn.NetConn) {
+	tb.Helper()
+	conn1, conn2, err := mockconn.NewMockConn(conf)
+	if err != nil {
+		tb.Fatalf("NewMockConn: %v", err)
+	}
+	tb.Cleanup(func() {
+		conn1.Close()
+		conn2.Close()
+	})
+	return conn1.(*mockconn.NetConn), conn2.(*mockconn.NetConn)
+}
+
+// CheckLeaks fails the test if goroutines started after it are still running when the test finishes, after
+// waiting a second for them to exit. Call it before NewPair so the pairs are closed before the check.
+// Goroutines of mockconn schedulers exit when there is no pending event, so they are waited for too.
+// It can not tell goroutines of parallel tests apart.
+func CheckLeaks(tb testing.TB) {
+	tb.Helper()
+	before := goroutines()
+	tb.Cleanup(func() {
+		tb.Helper()
+		var leaked []string
+		for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+			leaked = leaked[:0]
+			for id, stack := range goroutines() {
+				if _, ok := before[id]; !ok {
+					leaked = append(leaked, stack)
+				}
+			}
+			if len(leaked) == 0 || time.Now().After(deadline) {
+				break
+			}
+		}
+		if len(leaked) > 0 {
+			tb.Errorf("%d goroutines leaked:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+		}
+	})
+}
+
+// goroutines returns the stacks of running goroutines other than the caller by their ids.
+func goroutines() map[int]string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	stacks := make(map[int]string)
+	for i, stack := range strings.Split(string(buf), "\n\n") {
+		if i == 0 { // the caller
+			continue
+		}
+		// goroutine 1 [running]:
+		fields := strings.Fields(stack)
+		if len(fields) < 2 {
+			continue
+		}
+		if id, err := strconv.Atoi(fields[1]); err == nil {
+			stacks[id] = stack
+		}
+	}
+	return stacks
+}
+
+// AddFuzzSeeds adds mockconn.FuzzSeeds to the seed corpus of f, for a fuzz target taking a schedule []byte.
+func AddFuzzSeeds(f *testing.F) {
+	for _, seed := range mockconn.FuzzSeeds() {
+		f.Add(seed)
+	}
+}
+
+// Traffic is the traffic Run generates.
+type Traffic struct {
+	Count int           // number of packets
+	Size  int           // bytes of a packet, at least 16 for the sequence number and time, 16 if less
+	Rate  float64       // packets per second, as fast as the connection takes them if zero
+	Drain time.Duration // time to wait for packets in flight after all are written, 1s if zero
+}
+
+// Result is the traffic delivered by Run.
+type Result struct {
+	Sent      int             // packets written
+	Received  []int           // sequence numbers of packets read in order, from 0
+	Latencies []time.Duration // latency of packets read from they are written
+	Corrupted int             // packets read with a bad header
+	Duration  time.Duration   // time from the first write to the last read
+	Err       error           // the error stopping writes, nil if all are written
+}
+
+// Run writes traffic from one endpoint and reads it from the other, and returns what is delivered.
+// Packets carry their sequence number and the time they are written. Reading stops when all packets written
+// are read, or Drain after the last write. The read deadline of to is cleared after Run.
+func Run(tb testing.TB, from, to net.Conn, traffic Traffic) *Result {
+	tb.Helper()
+	if traffic.Size < headerLen {
+		traffic.Size = headerLen
+	}
+	if traffic.Drain == 0 {
+		traffic.Drain = time.Second
+	}
+
+	r := &Result{}
+	start := time.Now()
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		b := make([]byte, traffic.Size+1)
+		for len(r.Received)+r.Corrupted < traffic.Count {
+			n, err := to.Read(b)
+			if err != nil {
+				return
+			}
+			now := time.Now()
+			r.Duration = now.Sub(start)
+			if n != traffic.Size || !zero(b[headerLen:n]) {
+				r.Corrupted++
+				continue
+			}
+			seq := binary.BigEndian.Uint64(b)
+			sent := time.Unix(0, int64(binary.BigEndian.Uint64(b[8:])))
+			if seq >= uint64(traffic.Count) || sent.Before(start) || sent.After(now) {
+				r.Corrupted++
+				continue
+			}
+			r.Received = append(r.Received, int(seq))
+			r.Latencies = append(r.Latencies, now.Sub(sent))
+		}
+	}()
+
+	b := make([]byte, traffic.Size)
+	for i := 0; i < traffic.Count; i++ {
+		if traffic.Rate > 0 {
+			time.Sleep(time.Until(start.Add(time.Duration(float64(i) / traffic.Rate * float64(time.Second)))))
+		}
+		binary.BigEndian.PutUint64(b, uint64(i))
+		binary.BigEndian.PutUint64(b[8:], uint64(time.Now().UnixNano()))
+		if _, err := from.Write(b); err != nil {
+			r.Err = err
+			break
+		}
+		r.Sent++
+	}
+
+	select {
+	case <-readDone:
+	case <-time.After(traffic.Drain):
+		to.SetReadDeadline(time.Now())
+		<-readDone
+	}
+	to.SetReadDeadline(time.Time{})
+	return r
+}
+
+// Loss returns the rate of packets written but not read.
+func (r *Result) Loss() float64 {
+	if r.Sent == 0 {
+		return 0
+	}
+	return 1 - float64(len(r.Received))/float64(r.Sent)
+}
+
+// MeanLatency returns the mean latency of packets read.
+func (r *Result) MeanLatency() time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, l := range r.Latencies {
+		sum += l
+	}
+	return sum / time.Duration(len(r.Latencies))
+}
+
+// AssertDelivered asserts every packet written is read once, in order and not corrupted, and all are written.
+func AssertDelivered(tb testing.TB, r *Result) bool {
+	tb.Helper()
+	if r.Err != nil {
+		tb.Errorf("%d packets written, then write failed: %v", r.Sent, r.Err)
+		return false
+	}
+	if r.Corrupted > 0 {
+		tb.Errorf("%d packets corrupted", r.Corrupted)
+		return false
+	}
+	for i, seq := range r.Received {
+		if seq != i {
+			tb.Errorf("packet %d read at %d", seq, i)
+			return false
+		}
+	}
+	if len(r.Received) != r.Sent {
+		tb.Errorf("%d packets read of %d written", len(r.Received), r.Sent)
+		return false
+	}
+	return true
+}
+
+// AssertLossWithin asserts the loss rate is within tolerance of loss.
+func AssertLossWithin(tb testing.TB, r *Result, loss, tolerance float64) bool {
+	tb.Helper()
+	if math.Abs(r.Loss()-loss) > tolerance {
+		tb.Errorf("loss rate %.4f of %d packets is not within %v of %v", r.Loss(), r.Sent, tolerance, loss)
+		return false
+	}
+	return true
+}
+
+// AssertLatencyWithin asserts the mean latency is within tolerance of latency.
+func AssertLatencyWithin(tb testing.TB, r *Result, latency, tolerance time.Duration) bool {
+	tb.Helper()
+	if len(r.Latencies) == 0 {
+		tb.Errorf("no packet read")
+		return false
+	}
+	if d := r.MeanLatency() - latency; d > tolerance || d < -tolerance {
+		tb.Errorf("mean latency %v of %d packets is not within %v of %v", r.MeanLatency(), len(r.Latencies),
+			tolerance, latency)
+		return false
+	}
+	return true
+}
+
+func zero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+package mockconntest
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	mockconn "github.com/nknorg/mockconn-go"
+	"github.com/stretchr/testify/require"
+)
+
+// A testing.TB recording errors, cleanups are run by finish.
+type fakeTB struct {
+	testing.TB
+	cleanups []func()
+	errors   []string
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Cleanup(fn func()) {
+	f.cleanups = append(f.cleanups, fn)
+}
+
+func (f *fakeTB) Errorf(format string, args ...interface{}) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeTB) finish() {
+	for i := len(f.cleanups) - 1; i >= 0; i-- {
+		f.cleanups[i]()
+	}
+}
+
+// go test -v -run=TestNewPair
+func TestNewPair(t *testing.T) {
+	var alice, bob *mockconn.NetConn
+	t.Run("pair", func(t *testing.T) {
+		CheckLeaks(t)
+		alice, bob = NewPair(t, &mockconn.ConnConfig{Addr1: "Alice", Addr2: "Bob", Latency: time.Millisecond})
+		_, err := alice.Write([]byte("hello"))
+		require.Nil(t, err)
+	})
+
+	// closed when the subtest finishes
+	_, err := alice.Write([]byte("hello"))
+	require.ErrorIs(t, err, net.ErrClosed)
+	_, err = bob.Write([]byte("hello"))
+	require.ErrorIs(t, err, net.ErrClosed)
+}
+
+// go test -v -run=TestCheckLeaks
+func TestCheckLeaks(t *testing.T) {
+	f := &fakeTB{}
+	CheckLeaks(f)
+	stop := make(chan struct{})
+	go func() {
+		<-stop
+	}()
+	f.finish()
+	close(stop)
+
+	require.Equal(t, 1, len(f.errors))
+	require.Contains(t, f.errors[0], "1 goroutines leaked")
+	require.Contains(t, f.errors[0], "TestCheckLeaks")
+}
+
+// go test -v -run=TestRun
+func TestRun(t *testing.T) {
+	conf := &mockconn.ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: 1000, Latency: 20 * time.Millisecond}
+	alice, bob := NewPair(t, conf)
+
+	r := Run(t, alice, bob, Traffic{Count: 100, Size: 100})
+	AssertDelivered(t, r)
+	AssertLossWithin(t, r, 0, 0)
+	AssertLatencyWithin(t, r, conf.Latency, 5*time.Millisecond)
+
+	// the other direction at a rate
+	start := time.Now()
+	r = Run(t, bob, alice, Traffic{Count: 10, Rate: 100})
+	AssertDelivered(t, r)
+	require.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+}
+
+// go test -v -run=TestRunLoss
+func TestRunLoss(t *testing.T) {
+	conf := &mockconn.ConnConfig{Addr1: "Alice", Addr2: "Bob", Latency: time.Millisecond, Loss: 0.2, BufferSize: 100}
+	alice, bob := NewPair(t, conf)
+
+	r := Run(t, alice, bob, Traffic{Count: 1000, Drain: 100 * time.Millisecond})
+	require.Equal(t, 1000, r.Sent)
+	AssertLossWithin(t, r, 0.2, 0.05)
+
+	f := &fakeTB{}
+	require.False(t, AssertDelivered(f, r))
+	require.False(t, AssertLossWithin(f, r, 0, 0.01))
+	require.Equal(t, 2, len(f.errors))
+}
+
+// go test -v -run=TestAssertDelivered
+func TestAssertDelivered(t *testing.T) {
+	f := &fakeTB{}
+	require.False(t, AssertDelivered(f, &Result{Sent: 2, Received: []int{1, 0}}))
+	require.Contains(t, f.errors[0], "packet 1 read at 0")
+
+	require.False(t, AssertDelivered(f, &Result{Sent: 2, Received: []int{0, 1}, Corrupted: 1}))
+	require.False(t, AssertLatencyWithin(f, &Result{}, time.Millisecond, time.Millisecond))
+	require.True(t, AssertDelivered(f, &Result{Sent: 2, Received: []int{0, 1}}))
+	require.Equal(t, 3, len(f.errors))
+}
+
+// go test -fuzz=FuzzRun -run=^$ ./mockconntest
+func FuzzRun(f *testing.F) {
+	AddFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, schedule []byte) {
+		conf := &mockconn.ConnConfig{Addr1: "Alice", Addr2: "Bob", Latency: time.Millisecond}
+		alice, bob, err := mockconn.NewFuzzConn(conf, schedule)
+		require.Nil(t, err)
+		defer alice.Close()
+		defer bob.Close()
+
+		// packets may be lost, reordered or corrupted, but no more arrive than written
+		r := Run(t, alice, bob, Traffic{Count: 10, Drain: 50 * time.Millisecond})
+		require.Equal(t, 10, r.Sent)
+		require.LessOrEqual(t, len(r.Received)+r.Corrupted, 10)
+	})
+}
@@ -1,15 +1,19 @@
 package mockconn
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
 	ErrConnNotEstablished error = errors.New("NetConn is not established")
+	ErrReadPaused         error = errors.New("NetConn reading is paused")
+	ErrWritePaused        error = errors.New("NetConn writing is paused")
 )
 
 type NetConn struct {
@@ -20,6 +24,12 @@ type NetConn struct {
 	pauseRead  bool
 	writeMu    sync.RWMutex
 	pauseWrite bool
+
+	faultMu  sync.Mutex     // protect the fields below
+	written  int64          // bytes written, for faults triggered by byte offset
+	pending  []pendingFault // faults waiting for bytes written
+	failNext error          // error of the next write, set by FailNextWrite
+	vanished int32          // set to 1 by Vanish, closing the endpoint does not tell the peer
 }
 
 // An implement of net.Conn interface
@@ -29,48 +39,107 @@ func NewNetConn(sendConn, recvConn *UniConn) *NetConn {
 }
 
 func (nc *NetConn) Write(b []byte) (n int, err error) {
+	return nc.WriteContext(context.Background(), b)
+}
+
+// WriteContext writes like Write, and returns ctx.Err() if ctx is done before the data is sent.
+func (nc *NetConn) WriteContext(ctx context.Context, b []byte) (n int, err error) {
 	if nc.sendConn == nil {
 		return 0, ErrConnNotEstablished
 	}
 
 	nc.writeMu.RLock()
-	defer nc.writeMu.RUnlock()
-	if nc.pauseWrite {
-		return 0, errors.New("NetConn writing is paused")
+	pauseWrite := nc.pauseWrite
+	nc.writeMu.RUnlock()
+	if pauseWrite {
+		return 0, ErrWritePaused
+	}
+	if err = nc.takeFailure(); err != nil {
+		return 0, nc.sendConn.opError("write", err)
 	}
 
-	return nc.sendConn.Write(b)
+	n, err = nc.sendConn.WriteContext(ctx, b)
+	if n > 0 {
+		nc.wrote(n)
+	}
+	return n, err
 }
 
 func (nc *NetConn) Read(b []byte) (n int, err error) {
+	return nc.ReadContext(context.Background(), b)
+}
+
+// ReadContext reads like Read, and returns ctx.Err() if ctx is done before data arrives.
+func (nc *NetConn) ReadContext(ctx context.Context, b []byte) (n int, err error) {
 	if nc.recvConn == nil {
 		return 0, ErrConnNotEstablished
 	}
 
 	nc.readMu.RLock()
-	defer nc.readMu.RUnlock()
-	if nc.pauseRead {
-		return 0, errors.New("NetConn reading is paused")
+	pauseRead := nc.pauseRead
+	nc.readMu.RUnlock()
+	if pauseRead {
+		return 0, ErrReadPaused
 	}
 
-	return nc.recvConn.Read(b)
+	return nc.recvConn.ReadContext(ctx, b)
 }
 
+// Close closes the endpoint gracefully, the peer still reads data in flight and then gets io.EOF.
 func (nc *NetConn) Close() error {
 	if nc.sendConn == nil || nc.recvConn == nil {
 		return ErrConnNotEstablished
 	}
+	if atomic.LoadInt32(&nc.vanished) == 1 {
+		return nil
+	}
 
 	nc.sendConn.CloseWrite()
 	nc.recvConn.CloseRead()
 	return nil
 }
 
+// CloseGracefully closes the endpoint like Close after the peer has read the data written, like a lingering TCP
+// close. If ctx is done first, it returns ctx.Err() without waiting any more, the peer may still read the data.
+func (nc *NetConn) CloseGracefully(ctx context.Context) error {
+	if nc.sendConn == nil || nc.recvConn == nil {
+		return ErrConnNotEstablished
+	}
+	if atomic.LoadInt32(&nc.vanished) == 1 {
+		return nil
+	}
+
+	nc.sendConn.CloseWrite()
+	err := nc.sendConn.waitRead(ctx)
+	nc.recvConn.CloseRead()
+	return err
+}
+
+// Reset aborts the connection like a TCP RST, data in flight is discarded and both endpoints get ErrConnReset.
+func (nc *NetConn) Reset() error {
+	if nc.sendConn == nil || nc.recvConn == nil {
+		return ErrConnNotEstablished
+	}
+	if atomic.LoadInt32(&nc.vanished) == 1 {
+		return nil
+	}
+
+	nc.sendConn.Reset()
+	nc.recvConn.Reset()
+	return nil
+}
+
 func (nc *NetConn) CloseRead() error {
+	if atomic.LoadInt32(&nc.vanished) == 1 {
+		return nil
+	}
 	return nc.recvConn.CloseRead()
 }
 
 func (nc *NetConn) CloseWrite() error {
+	if atomic.LoadInt32(&nc.vanished) == 1 {
+		return nil
+	}
 	return nc.sendConn.CloseWrite()
 }
 
@@ -96,11 +165,12 @@ func (nc *NetConn) SetDeadline(t time.Time) error {
 		return ErrConnNotEstablished
 	}
 
-	err := nc.sendConn.SetDeadline(t)
+	// the read deadline of sendConn belongs to the peer
+	err := nc.sendConn.SetWriteDeadline(t)
 	if err != nil {
 		return err
 	}
-	err = nc.recvConn.SetDeadline(t)
+	err = nc.recvConn.SetReadDeadline(t)
 	if err != nil {
 		return err
 	}
@@ -122,6 +192,14 @@ func (nc *NetConn) SetWriteDeadline(t time.Time) error {
 	return nc.sendConn.SetWriteDeadline(t)
 }
 
+// Metrics returns the metrics of the receiving direction.
+func (nc *NetConn) Metrics() Metrics {
+	if nc.recvConn == nil {
+		return Metrics{}
+	}
+	return nc.recvConn.Metrics()
+}
+
 func (nc *NetConn) PrintMetrics() {
 	if nc.recvConn == nil {
 		return
@@ -129,6 +207,24 @@ func (nc *NetConn) PrintMetrics() {
 	nc.recvConn.PrintMetrics()
 }
 
+// SetThroughput changes the throughput of both directions.
+func (nc *NetConn) SetThroughput(throughput uint) {
+	nc.sendConn.SetThroughput(throughput)
+	nc.recvConn.SetThroughput(throughput)
+}
+
+// SetLatency changes the latency of both directions.
+func (nc *NetConn) SetLatency(latency time.Duration) {
+	nc.sendConn.SetLatency(latency)
+	nc.recvConn.SetLatency(latency)
+}
+
+// SetLoss changes the loss rate of both directions.
+func (nc *NetConn) SetLoss(loss float32) {
+	nc.sendConn.SetLoss(loss)
+	nc.recvConn.SetLoss(loss)
+}
+
 func (nc *NetConn) String() string {
 	return fmt.Sprintf("NetConn endpoint %v", nc.LocalAddr())
 }
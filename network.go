@@ -0,0 +1,220 @@
+package mockconn
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrConnRefused error = syscall.ECONNREFUSED // dial to an address without listener
+	ErrTimedOut    error = syscall.ETIMEDOUT    // handshake packets are lost more than the retries
+	ErrAddrInUse   error = syscall.EADDRINUSE   // listen on an address with a listener
+)
+
+// NetworkConfig is the config of a MockNetwork.
+type NetworkConfig struct {
+	Link          ConnConfig    // config of every connection dialed, Addr1 and Addr2 are set by Dial
+	TLSRoundTrips int           // round trips of a TLS handshake after the TCP handshake, 1 for TLS 1.3, 2 for TLS 1.2
+	InitialRTO    time.Duration // time to retransmit a lost handshake packet, doubled for every retry, 1s if zero
+	Retries       int           // retries of a handshake packet before Dial fails with ErrTimedOut, 6 if zero
+}
+
+// MockNetwork connects endpoints dialing addresses to the listeners on them, over connections of the link config.
+// Dialing costs a handshake: SYN and SYN-ACK take a round trip of 2 * Latency before Dial returns, and the ACK
+// arrives at the listener half a round trip later, 1.5 RTT in all, and every TLS round trip adds one RTT to both.
+// Handshake packets are lost at the rate of Loss and retransmitted after the RTO. Dial to an address without
+// listener is refused after a round trip.
+type MockNetwork struct {
+	conf NetworkConfig
+
+	mu        sync.Mutex // protect the fields below
+	listeners map[string]*Listener
+	nextPort  int
+}
+
+// NewMockNetwork creates a network without listeners.
+func NewMockNetwork(conf *NetworkConfig) *MockNetwork {
+	n := &MockNetwork{conf: *conf, listeners: make(map[string]*Listener), nextPort: 49152}
+	if n.conf.InitialRTO == 0 {
+		n.conf.InitialRTO = time.Second
+	}
+	if n.conf.Retries == 0 {
+		n.conf.Retries = 6
+	}
+	return n
+}
+
+// Listen listens on address for connections dialed to it, network is ignored like in Dial.
+func (n *MockNetwork) Listen(network, address string) (net.Listener, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.listeners[address]; ok {
+		return nil, &net.OpError{Op: "listen", Net: ClientAddr{}.Network(), Addr: ClientAddr{addr: address},
+			Err: ErrAddrInUse}
+	}
+	l := &Listener{network: n, addr: address}
+	n.listeners[address] = l
+	return l, nil
+}
+
+// Dial dials address like DialContext without a context.
+func (n *MockNetwork) Dial(network, address string) (net.Conn, error) {
+	return n.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to the listener on address after the handshake, or returns ctx.Err() if ctx is done first.
+// network is ignored, so it can be used as the dial function of net/http or other pools. The endpoint dialing gets
+// an ephemeral address.
+func (n *MockNetwork) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	n.mu.Lock()
+	local := fmt.Sprintf("client:%d", n.nextPort)
+	n.nextPort++
+	l := n.listeners[address]
+	n.mu.Unlock()
+
+	opError := func(err error) error {
+		return &net.OpError{Op: "dial", Net: ClientAddr{}.Network(), Source: ClientAddr{addr: local},
+			Addr: ClientAddr{addr: address}, Err: err}
+	}
+
+	rtt := 2 * n.conf.Link.Latency
+	if l == nil {
+		if err := sleepContext(ctx, rtt); err != nil {
+			return nil, opError(err)
+		}
+		return nil, opError(ErrConnRefused)
+	}
+
+	dial, accept, err := n.handshake()
+	if err := sleepContext(ctx, dial); err != nil {
+		return nil, opError(err)
+	}
+	if err != nil {
+		return nil, opError(err)
+	}
+
+	conf := n.conf.Link
+	conf.Addr1, conf.Addr2 = local, address
+	clientConn, serverConn, err := NewMockConn(&conf)
+	if err != nil {
+		return nil, opError(err)
+	}
+	scheduler := conf.Scheduler
+	if scheduler == nil {
+		scheduler = getDefaultScheduler()
+	}
+	scheduler.schedule(time.Now().Add(accept-dial), nil, func() {
+		l.deliver(serverConn.(*NetConn))
+	})
+	return clientConn, nil
+}
+
+// handshake draws the fate of handshake packets, and returns the time until the dialer is connected and the time
+// until the listener accepts, or the time until dial fails with ErrTimedOut.
+func (n *MockNetwork) handshake() (dial, accept time.Duration, err error) {
+	latency, loss := n.conf.Link.Latency, n.conf.Link.Loss
+
+	// SYN, SYN-ACK, ACK, and a packet each way for every TLS round trip
+	nPacket := 3 + 2*n.conf.TLSRoundTrips
+	var t time.Duration
+	for i := 0; i < nPacket; i++ {
+		rto := n.conf.InitialRTO
+		for retry := 0; rand.Float32() < loss; retry++ {
+			if retry == n.conf.Retries {
+				return t, 0, ErrTimedOut
+			}
+			t += rto
+			rto *= 2
+		}
+		t += latency
+		if i == nPacket-2 { // the last packet to the dialer
+			dial = t
+		}
+	}
+	return dial, t, nil
+}
+
+// Wait for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Listener is a listener of a MockNetwork, it implements net.Listener.
+type Listener struct {
+	network *MockNetwork
+	addr    string
+
+	mu      sync.Mutex // protect the fields below
+	backlog []*NetConn // connections handshaken but not accepted
+	ready   signal     // there may be a connection to accept
+	closed  bool
+}
+
+// Accept waits for the next connection dialed to the listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		l.mu.Lock()
+		if len(l.backlog) > 0 {
+			nc := l.backlog[0]
+			l.backlog[0] = nil
+			l.backlog = l.backlog[1:]
+			l.mu.Unlock()
+			return nc, nil
+		}
+		if l.closed {
+			l.mu.Unlock()
+			return nil, &net.OpError{Op: "accept", Net: ClientAddr{}.Network(), Addr: l.Addr(), Err: net.ErrClosed}
+		}
+		ready := l.ready.wait()
+		l.mu.Unlock()
+		<-ready
+	}
+}
+
+// Close stops listening, connections not accepted are reset and Accept returns net.ErrClosed.
+func (l *Listener) Close() error {
+	l.network.mu.Lock()
+	if l.network.listeners[l.addr] == l {
+		delete(l.network.listeners, l.addr)
+	}
+	l.network.mu.Unlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	for _, nc := range l.backlog {
+		nc.Reset()
+	}
+	l.backlog = nil
+	l.ready.broadcast()
+	return nil
+}
+
+// Addr returns the address listening on.
+func (l *Listener) Addr() net.Addr {
+	return ClientAddr{addr: l.addr}
+}
+
+// deliver puts a connection whose handshake is done into the backlog, or resets it if the listener is closed.
+func (l *Listener) deliver(nc *NetConn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		nc.Reset()
+		return
+	}
+	l.backlog = append(l.backlog, nc)
+	l.ready.broadcast()
+}
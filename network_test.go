@@ -0,0 +1,119 @@
+package mockconn
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestDial
+func TestDial(t *testing.T) {
+	latency := 20 * time.Millisecond
+	for _, tlsRoundTrips := range []int{0, 1} {
+		conf := &NetworkConfig{Link: ConnConfig{Latency: latency}, TLSRoundTrips: tlsRoundTrips}
+		network := NewMockNetwork(conf)
+		l, err := network.Listen("tcp", "server:80")
+		require.Nil(t, err)
+
+		// the server reports to the test goroutine, require can not stop the test from another goroutine
+		type accepted struct {
+			after time.Duration
+			err   error
+		}
+		start := time.Now()
+		acceptCh := make(chan accepted, 2)
+		go func() {
+			conn, err := l.Accept()
+			acceptCh <- accepted{after: time.Since(start), err: err}
+			if err != nil {
+				return
+			}
+
+			b := make([]byte, 1024)
+			n, err := conn.Read(b)
+			if err == nil {
+				_, err = conn.Write(b[:n])
+			}
+			acceptCh <- accepted{err: err}
+			conn.Close()
+		}()
+
+		// Dial returns after a round trip, and the listener accepts half a round trip later
+		rtt := 2 * latency * time.Duration(1+tlsRoundTrips)
+		conn, err := network.Dial("tcp", "server:80")
+		require.Nil(t, err)
+		dial := time.Since(start)
+		require.GreaterOrEqual(t, dial, rtt)
+		require.Less(t, dial, rtt+latency/2)
+		a := <-acceptCh
+		require.Nil(t, a.err)
+		require.GreaterOrEqual(t, a.after, rtt+latency)
+		require.Equal(t, "server:80", conn.RemoteAddr().String())
+
+		_, err = conn.Write([]byte("hello"))
+		require.Nil(t, err)
+		b := make([]byte, 1024)
+		n, err := conn.Read(b)
+		require.Nil(t, err)
+		require.Equal(t, "hello", string(b[:n]))
+		require.Nil(t, (<-acceptCh).err)
+		conn.Close()
+		l.Close()
+	}
+}
+
+// go test -v -run=TestDialRefused
+func TestDialRefused(t *testing.T) {
+	network := NewMockNetwork(&NetworkConfig{Link: ConnConfig{Latency: 10 * time.Millisecond}})
+	start := time.Now()
+	_, err := network.Dial("tcp", "server:80")
+	require.ErrorIs(t, err, syscall.ECONNREFUSED)
+	require.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	// refused after the listener is closed
+	l, err := network.Listen("tcp", "server:80")
+	require.Nil(t, err)
+	_, err = network.Listen("tcp", "server:80")
+	require.ErrorIs(t, err, syscall.EADDRINUSE)
+	require.Nil(t, l.Close())
+	_, err = l.Accept()
+	require.ErrorIs(t, err, net.ErrClosed)
+	_, err = network.Dial("tcp", "server:80")
+	require.ErrorIs(t, err, syscall.ECONNREFUSED)
+}
+
+// go test -v -run=TestDialTimeout
+func TestDialTimeout(t *testing.T) {
+	// every SYN is lost, dial fails after retries
+	conf := &NetworkConfig{Link: ConnConfig{Latency: 10 * time.Millisecond, Loss: 1}, InitialRTO: 10 * time.Millisecond,
+		Retries: 2}
+	network := NewMockNetwork(conf)
+	l, err := network.Listen("tcp", "server:80")
+	require.Nil(t, err)
+	defer l.Close()
+
+	start := time.Now()
+	_, err = network.Dial("tcp", "server:80")
+	require.ErrorIs(t, err, syscall.ETIMEDOUT)
+	require.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond)
+
+	// or when the context is done while waiting to retransmit the SYN
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	conf.InitialRTO = time.Second
+	network = NewMockNetwork(conf)
+	l, err = network.Listen("tcp", "server:80")
+	require.Nil(t, err)
+	defer l.Close()
+	start = time.Now()
+	_, err = network.DialContext(ctx, "tcp", "server:80")
+	require.Less(t, time.Since(start), conf.InitialRTO)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	var opErr *net.OpError
+	require.ErrorAs(t, err, &opErr)
+	require.True(t, opErr.Timeout())
+}
@@ -0,0 +1,99 @@
+package mockconn
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// DropReason tells why a packet is dropped.
+type DropReason int
+
+const (
+	DropLoss      DropReason = iota // random loss of the link
+	DropQueue                       // dropped by the queue discipline
+	DropWrite                       // non-blocking write when the link can not take it
+	DropClosed                      // discarded when the connection is closed before it is read
+	DropIntercept                   // dropped by an interceptor
+	DropBlackhole                   // swallowed by a blackhole fault
+
+	numDropReasons = iota
+)
+
+func (r DropReason) String() string {
+	switch r {
+	case DropLoss:
+		return "loss"
+	case DropQueue:
+		return "queue"
+	case DropWrite:
+		return "write"
+	case DropClosed:
+		return "closed"
+	case DropIntercept:
+		return "intercept"
+	case DropBlackhole:
+		return "blackhole"
+	default:
+		return "unknown"
+	}
+}
+
+// Observer is notified of the fate of every packet of a connection. A packet written is enqueued, then it is
+// either dropped, or sent by the link and then delivered to the reader or dropped when the connection is closed.
+// Callbacks are called synchronously while the connection is locked, they should be short and
+// not call methods of the connection other than LocalAddr and RemoteAddr. b is only valid during the call.
+type Observer interface {
+	OnEnqueue(uc *UniConn, b []byte)                        // Write hands a packet to the connection
+	OnSend(uc *UniConn, b []byte)                           // the packet passes the link
+	OnDrop(uc *UniConn, b []byte, reason DropReason)        // the packet is dropped
+	OnDeliver(uc *UniConn, b []byte, latency time.Duration) // the packet is read, latency is from it was sent
+	OnClose(uc *UniConn)                                    // both reading and writing of the connection are closed
+}
+
+// NopObserver does nothing on every event, embed it to implement only some callbacks of Observer.
+type NopObserver struct{}
+
+func (NopObserver) OnEnqueue(uc *UniConn, b []byte)                        {}
+func (NopObserver) OnSend(uc *UniConn, b []byte)                           {}
+func (NopObserver) OnDrop(uc *UniConn, b []byte, reason DropReason)        {}
+func (NopObserver) OnDeliver(uc *UniConn, b []byte, latency time.Duration) {}
+func (NopObserver) OnClose(uc *UniConn)                                    {}
+
+func (uc *UniConn) onEnqueue(dt *dataWithTime) {
+	for _, o := range uc.observers {
+		o.OnEnqueue(uc, dt.data)
+	}
+}
+
+func (uc *UniConn) onSend(dt *dataWithTime) {
+	for _, o := range uc.observers {
+		o.OnSend(uc, dt.data)
+	}
+}
+
+func (uc *UniConn) onDrop(dt *dataWithTime, reason DropReason) {
+	uc.log(slog.LevelDebug, "packet dropped", "reason", reason, "size", len(dt.data))
+	for _, o := range uc.observers {
+		o.OnDrop(uc, dt.data, reason)
+	}
+}
+
+func (uc *UniConn) onDeliver(dt *dataWithTime, latency time.Duration) {
+	for _, o := range uc.observers {
+		o.OnDeliver(uc, dt.data, latency)
+	}
+}
+
+// onClose notifies observers once after both reading and writing are closed.
+func (uc *UniConn) onClose() {
+	if uc.closeReadCtx.Err() == nil || uc.closeWriteCtx.Err() == nil {
+		return
+	}
+	uc.closeOnce.Do(func() {
+		uc.log(slog.LevelInfo, "connection closed", "reset", atomic.LoadInt32(&uc.reset) == 1, "metrics", uc.metrics())
+		for _, o := range uc.observers {
+			o.OnClose(uc)
+		}
+	})
+}
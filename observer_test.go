@@ -0,0 +1,123 @@
+package mockconn
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// An observer counting events.
+type countObserver struct {
+	mu      sync.Mutex
+	enqueue int
+	send    int
+	drop    map[DropReason]int
+	deliver int
+	latency time.Duration // min latency delivered
+	close   int
+}
+
+func newCountObserver() *countObserver {
+	return &countObserver{drop: make(map[DropReason]int)}
+}
+
+func (o *countObserver) OnEnqueue(uc *UniConn, b []byte) {
+	o.mu.Lock()
+	o.enqueue++
+	o.mu.Unlock()
+}
+
+func (o *countObserver) OnSend(uc *UniConn, b []byte) {
+	o.mu.Lock()
+	o.send++
+	o.mu.Unlock()
+}
+
+func (o *countObserver) OnDrop(uc *UniConn, b []byte, reason DropReason) {
+	o.mu.Lock()
+	o.drop[reason]++
+	o.mu.Unlock()
+}
+
+func (o *countObserver) OnDeliver(uc *UniConn, b []byte, latency time.Duration) {
+	o.mu.Lock()
+	o.deliver++
+	if o.latency == 0 || latency < o.latency {
+		o.latency = latency
+	}
+	o.mu.Unlock()
+}
+
+func (o *countObserver) OnClose(uc *UniConn) {
+	o.mu.Lock()
+	o.close++
+	o.mu.Unlock()
+}
+
+// go test -v -run=TestObserver
+func TestObserver(t *testing.T) {
+	o := newCountObserver()
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(1000), Latency: 10 * time.Millisecond, Loss: 0.2,
+		Queue: QueueConfig{Discipline: QueueDropTail, Limit: 10}, Observers: []Observer{o}}
+	uc, err := NewUniConn(conf)
+	require.Nil(t, err)
+
+	nPacket := 100
+	b := make([]byte, 1024)
+	for i := 0; i < nPacket; i++ {
+		_, err = uc.Write(b)
+		require.Nil(t, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		_, err = uc.Read(b)
+		require.Nil(t, err)
+	}
+	uc.Close()
+	uc.Close()
+
+	m := uc.Metrics()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	require.Equal(t, nPacket, o.enqueue)
+	require.Equal(t, int(m.QueueDrop), o.drop[DropQueue])
+	require.Equal(t, int(m.Loss), o.drop[DropLoss])
+	require.Equal(t, 5, o.deliver)
+	require.GreaterOrEqual(t, o.latency, conf.Latency)
+	require.Greater(t, o.drop[DropClosed], 0)
+	// every packet has one fate
+	require.Equal(t, o.enqueue, o.send+o.drop[DropQueue]+o.drop[DropLoss])
+	require.Equal(t, o.send, o.deliver+o.drop[DropClosed])
+	require.Equal(t, 1, o.close)
+}
+
+// An observer only interested in close.
+type closeObserver struct {
+	NopObserver
+	mu     sync.Mutex
+	closed []string
+}
+
+func (o *closeObserver) OnClose(uc *UniConn) {
+	o.mu.Lock()
+	o.closed = append(o.closed, uc.String())
+	o.mu.Unlock()
+}
+
+// go test -v -run=TestNopObserver
+func TestNopObserver(t *testing.T) {
+	o := &closeObserver{}
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Observers: []Observer{o}}
+	alice, bob, err := NewMockConn(conf)
+	require.Nil(t, err)
+
+	_, err = alice.Write([]byte("hello"))
+	require.Nil(t, err)
+	alice.Close()
+	require.Empty(t, o.closed) // Bob may still read
+
+	bob.Close()
+	require.Len(t, o.closed, 2)
+}
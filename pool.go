@@ -0,0 +1,160 @@
+package mockconn
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// Packet buffers are pooled by size classes of power of 2, from 64 bytes to 64K bytes.
+// Larger packets are allocated and left to GC.
+const (
+	minPoolShift = 6
+	maxPoolShift = 16
+)
+
+var packetPools [maxPoolShift - minPoolShift + 1]sync.Pool
+
+// The size class of a buffer with n bytes, -1 if it is too large to pool.
+func sizeClass(n int) int {
+	if n <= 1<<minPoolShift {
+		return 0
+	}
+	shift := bits.Len(uint(n - 1))
+	if shift > maxPoolShift {
+		return -1
+	}
+	return shift - minPoolShift
+}
+
+// getPacket returns a packet holding a copy of b, its buffer is taken from pool.
+func getPacket(b []byte) *dataWithTime {
+	c := sizeClass(len(b))
+	if c < 0 {
+		return &dataWithTime{data: append([]byte(nil), b...)}
+	}
+
+	dt, ok := packetPools[c].Get().(*dataWithTime)
+	if !ok {
+		dt = &dataWithTime{data: make([]byte, 0, 1<<(c+minPoolShift))}
+	}
+	dt.data = append(dt.data[:0], b...)
+	return dt
+}
+
+// putPacket returns the packet to pool after it is delivered or dropped, it should not be used any more.
+func putPacket(dt *dataWithTime) {
+	c := sizeClass(cap(dt.data))
+	if c < 0 || cap(dt.data) != 1<<(c+minPoolShift) {
+		return
+	}
+
+	*dt = dataWithTime{data: dt.data[:0]}
+	packetPools[c].Put(dt)
+}
+
+// A FIFO of packets on a ring buffer, so pushing and popping packets do not allocate once it has grown.
+type packetRing struct {
+	buf  []*dataWithTime
+	head int
+	n    int
+}
+
+func (r *packetRing) len() int {
+	return r.n
+}
+
+func (r *packetRing) push(dt *dataWithTime) {
+	if r.n == len(r.buf) {
+		buf := make([]*dataWithTime, 2*len(r.buf)+1)
+		for i := 0; i < r.n; i++ {
+			buf[i] = r.buf[(r.head+i)%len(r.buf)]
+		}
+		r.buf, r.head = buf, 0
+	}
+	r.buf[(r.head+r.n)%len(r.buf)] = dt
+	r.n++
+}
+
+// peek returns the first packet, nil if the ring is empty.
+func (r *packetRing) peek() *dataWithTime {
+	if r.n == 0 {
+		return nil
+	}
+	return r.buf[r.head]
+}
+
+// pop removes and returns the first packet, nil if the ring is empty.
+func (r *packetRing) pop() *dataWithTime {
+	if r.n == 0 {
+		return nil
+	}
+	dt := r.buf[r.head]
+	r.buf[r.head] = nil
+	r.head = (r.head + 1) % len(r.buf)
+	r.n--
+	return dt
+}
+
+// A priority queue of packets by the time they are due, packets due at the same time are in the order pushed.
+type packetHeap struct {
+	packets []*dataWithTime
+	seq     uint64
+}
+
+func (h *packetHeap) len() int {
+	return len(h.packets)
+}
+
+func (h *packetHeap) less(i, j int) bool {
+	a, b := h.packets[i], h.packets[j]
+	return a.due.Before(b.due) || (a.due.Equal(b.due) && a.seq < b.seq)
+}
+
+func (h *packetHeap) push(dt *dataWithTime) {
+	h.seq++
+	dt.seq = h.seq
+	h.packets = append(h.packets, dt)
+	for i := len(h.packets) - 1; i > 0; {
+		parent := (i - 1) / 2
+		if !h.less(i, parent) {
+			break
+		}
+		h.packets[i], h.packets[parent] = h.packets[parent], h.packets[i]
+		i = parent
+	}
+}
+
+// peek returns the first packet due, nil if the heap is empty.
+func (h *packetHeap) peek() *dataWithTime {
+	if len(h.packets) == 0 {
+		return nil
+	}
+	return h.packets[0]
+}
+
+// pop removes and returns the first packet due, nil if the heap is empty.
+func (h *packetHeap) pop() *dataWithTime {
+	n := len(h.packets) - 1
+	if n < 0 {
+		return nil
+	}
+	dt := h.packets[0]
+	h.packets[0] = h.packets[n]
+	h.packets[n] = nil
+	h.packets = h.packets[:n]
+	for i := 0; ; {
+		min, left, right := i, 2*i+1, 2*i+2
+		if left < n && h.less(left, min) {
+			min = left
+		}
+		if right < n && h.less(right, min) {
+			min = right
+		}
+		if min == i {
+			break
+		}
+		h.packets[i], h.packets[min] = h.packets[min], h.packets[i]
+		i = min
+	}
+	return dt
+}
@@ -0,0 +1,82 @@
+package mockconn
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestSizeClass
+func TestSizeClass(t *testing.T) {
+	require.Equal(t, 0, sizeClass(1))
+	require.Equal(t, 0, sizeClass(64))
+	require.Equal(t, 1, sizeClass(65))
+	require.Equal(t, 4, sizeClass(1024))
+	require.Equal(t, maxPoolShift-minPoolShift, sizeClass(1<<maxPoolShift))
+	require.Equal(t, -1, sizeClass(1<<maxPoolShift+1))
+
+	dt := getPacket(make([]byte, 1000))
+	require.Equal(t, 1000, len(dt.data))
+	require.Equal(t, 1024, cap(dt.data))
+	putPacket(dt)
+}
+
+// go test -v -run=TestWriteCopy
+func TestWriteCopy(t *testing.T) {
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(100), Latency: 20 * time.Millisecond}
+	uc, err := NewUniConn(conf)
+	require.Nil(t, err)
+
+	// caller mutates its buffer while data is in flight
+	b := []byte("hello")
+	_, err = uc.Write(b)
+	require.Nil(t, err)
+	copy(b, "world")
+
+	b2 := make([]byte, 3)
+	n, err := uc.Read(b2)
+	require.Nil(t, err)
+	require.Equal(t, "hel", string(b2[:n]))
+	n, err = uc.Read(b2)
+	require.Nil(t, err)
+	require.Equal(t, "lo", string(b2[:n]))
+	uc.Close()
+}
+
+// go test -bench=BenchmarkWriteRead -run=^$
+func BenchmarkWriteRead(b *testing.B) {
+	for _, c := range []struct {
+		name string
+		conf ConnConfig
+	}{
+		// packets are due at once, nothing is scheduled and Read does not wait
+		{"unlimited", ConnConfig{Addr1: "Alice", Addr2: "Bob", BufferSize: 1024}},
+		// every packet schedules an arrival event and Read waits for it
+		{"latency", ConnConfig{Addr1: "Alice", Addr2: "Bob", BufferSize: 1024, Latency: time.Microsecond}},
+		// the link wakes up writers too
+		{"throughput", ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(1000000), Latency: time.Microsecond}},
+	} {
+		for _, size := range []int{64, 1024, 16384} {
+			c, size := c, size
+			b.Run(fmt.Sprintf("%v/size=%v", c.name, size), func(b *testing.B) {
+				benchmarkWriteRead(b, &c.conf, size)
+			})
+		}
+	}
+}
+
+func benchmarkWriteRead(b *testing.B, conf *ConnConfig, size int) {
+	uc, _ := NewUniConn(conf)
+	defer uc.Close()
+
+	wb, rb := make([]byte, size), make([]byte, size)
+	b.ReportAllocs()
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		uc.Write(wb)
+		uc.Read(rb)
+	}
+}
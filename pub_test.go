@@ -2,12 +2,23 @@ package mockconn
 
 import (
 	"encoding/binary"
-	"log"
+	"log/slog"
 	"net"
+	"os"
+	"testing"
 	"time"
 )
 
-func WritePacktes(writer net.Conn, nPackets int, sendCh chan []int64) {
+// testLogger logs helpers' reports at info level, they are shown with go test -v only.
+func testLogger() *slog.Logger {
+	level := slog.LevelWarn
+	if testing.Verbose() {
+		level = slog.LevelInfo
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+}
+
+func WritePackets(writer net.Conn, nPackets int, sendCh chan []int64) {
 	var sendSeq []int64
 
 	seq := int64(1)
@@ -26,8 +37,8 @@ func WritePacktes(writer net.Conn, nPackets int, sendCh chan []int64) {
 	}
 	dur := time.Since(t1)
 	throughput := float64(count) / dur.Seconds()
-	log.Printf("%v send %v packets in %v ms, throughput is: %.1f packets/s \n",
-		writer.LocalAddr(), count, dur.Milliseconds(), throughput)
+	testLogger().Info("packets sent", "local", writer.LocalAddr(), "remote", writer.RemoteAddr(), "count", count,
+		"duration", dur, "throughput", throughput)
 
 	sendCh <- sendSeq // return the sequences written
 }
@@ -51,13 +62,13 @@ func ReadPackets(reader net.Conn, nPackets int, latency time.Duration, recvCh ch
 	dur2 := dur - latency
 	throughput := float64(count) / dur2.Seconds()
 
-	log.Printf("%v read %v packets in %v ms, deduct %v ms latency, throughput is: %.1f packets/s \n",
-		reader.LocalAddr(), count, dur.Milliseconds(), latency.Milliseconds(), throughput)
+	logger := testLogger().With("local", reader.LocalAddr(), "remote", reader.RemoteAddr())
+	logger.Info("packets read", "count", count, "duration", dur, "latency", latency, "throughput", throughput)
 
 	if uc, ok := reader.(*UniConn); ok {
-		uc.PrintMetrics()
+		logger.Info("metrics", "metrics", uc.Metrics())
 	} else if nc, ok := reader.(*NetConn); ok {
-		nc.PrintMetrics()
+		logger.Info("metrics", "metrics", nc.Metrics())
 	}
 
 	recvCh <- recvSeq // return the sequences read
@@ -0,0 +1,254 @@
+package mockconn
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// QueueDiscipline decides how the bottleneck buffer in front of the link drops packets.
+type QueueDiscipline int
+
+const (
+	QueueNone     QueueDiscipline = iota // no queue, writers are held back by the link until it can send
+	QueueDropTail                        // drop arriving packets when the queue is full
+	QueueRED                             // Random Early Detection, drop arriving packets by the average queue length
+	QueueCoDel                           // Controlled Delay, drop departing packets by their queuing delay
+)
+
+// The config of the bottleneck queue.
+// When a discipline is set, Write puts packets into the queue and returns immediately,
+// the link takes them out at Throughput, packets the discipline drops are counted as queue drops.
+type QueueConfig struct {
+	Discipline QueueDiscipline
+	Limit      uint          // max packets in the queue, BufferSize is used if it is not set
+	ByteLimit  uint          // max bytes in the queue, without byte limit if zero
+	MinThresh  float64       // RED: average queue length (packets) to start early dropping, default Limit/4
+	MaxThresh  float64       // RED: average queue length (packets) to drop every packet, default 3*Limit/4
+	MaxP       float64       // RED: drop probability when average queue length reaches MaxThresh, default 0.1
+	Weight     float64       // RED: weight of the average queue length moving average, default 0.002
+	Target     time.Duration // CoDel: acceptable standing queuing delay, default 5ms
+	Interval   time.Duration // CoDel: window to observe the standing delay, default 100ms
+}
+
+// The queue in front of the link, it is not safe for concurrent use.
+type queue interface {
+	// put a packet into queue, return false if the packet is dropped
+	enqueue(dt *dataWithTime, now time.Time) bool
+	// take the head packet out of queue, return nil if queue is empty. dropped are packets dropped on dequeue.
+	dequeue(now time.Time) (dt *dataWithTime, dropped []*dataWithTime)
+	len() int
+}
+
+func newQueue(conf *QueueConfig, bufferSize uint) queue {
+	limit := conf.Limit
+	if limit == 0 {
+		limit = bufferSize
+	}
+	if limit == 0 {
+		limit = 1
+	}
+	f := fifo{limit: int(limit), byteLimit: int(conf.ByteLimit)}
+
+	switch conf.Discipline {
+	case QueueRED:
+		q := &red{fifo: f, minThresh: conf.MinThresh, maxThresh: conf.MaxThresh, maxP: conf.MaxP, weight: conf.Weight}
+		if q.minThresh == 0 {
+			q.minThresh = float64(limit) / 4
+		}
+		if q.maxThresh == 0 {
+			q.maxThresh = 3 * float64(limit) / 4
+		}
+		if q.maxThresh <= q.minThresh {
+			q.maxThresh = q.minThresh + 1
+		}
+		if q.maxP == 0 {
+			q.maxP = 0.1
+		}
+		if q.weight == 0 {
+			q.weight = 0.002
+		}
+		return q
+
+	case QueueCoDel:
+		q := &codel{fifo: f, target: conf.Target, interval: conf.Interval}
+		if q.target == 0 {
+			q.target = 5 * time.Millisecond
+		}
+		if q.interval == 0 {
+			q.interval = 100 * time.Millisecond
+		}
+		return q
+
+	default:
+		return &f
+	}
+}
+
+// First in first out queue with drop-tail on packet and byte limit.
+type fifo struct {
+	limit     int
+	byteLimit int
+
+	packets packetRing
+	bytes   int
+}
+
+func (q *fifo) full(dt *dataWithTime) bool {
+	if q.packets.len() >= q.limit {
+		return true
+	}
+	return q.byteLimit > 0 && q.bytes+len(dt.data) > q.byteLimit
+}
+
+func (q *fifo) push(dt *dataWithTime, now time.Time) {
+	dt.enqueued = now
+	q.packets.push(dt)
+	q.bytes += len(dt.data)
+}
+
+func (q *fifo) pop() *dataWithTime {
+	dt := q.packets.pop()
+	if dt == nil {
+		return nil
+	}
+	q.bytes -= len(dt.data)
+	return dt
+}
+
+func (q *fifo) enqueue(dt *dataWithTime, now time.Time) bool {
+	if q.full(dt) {
+		return false
+	}
+	q.push(dt, now)
+	return true
+}
+
+func (q *fifo) dequeue(now time.Time) (*dataWithTime, []*dataWithTime) {
+	return q.pop(), nil
+}
+
+func (q *fifo) len() int {
+	return q.packets.len()
+}
+
+// Random Early Detection, drop arriving packets with a probability growing with the average queue length.
+type red struct {
+	fifo
+	minThresh float64
+	maxThresh float64
+	maxP      float64
+	weight    float64
+
+	avg   float64 // moving average of queue length
+	count int     // packets accepted since last drop
+}
+
+func (q *red) enqueue(dt *dataWithTime, now time.Time) bool {
+	q.avg = (1-q.weight)*q.avg + q.weight*float64(q.packets.len())
+
+	drop := false
+	switch {
+	case q.full(dt) || q.avg >= q.maxThresh:
+		drop = true
+	case q.avg >= q.minThresh:
+		pb := q.maxP * (q.avg - q.minThresh) / (q.maxThresh - q.minThresh)
+		pa := 1.0
+		if d := 1 - float64(q.count)*pb; d > 0 {
+			pa = pb / d
+		}
+		drop = rand.Float64() < pa
+	}
+
+	if drop {
+		q.count = 0
+		return false
+	}
+	q.count++
+	q.push(dt, now)
+	return true
+}
+
+// Controlled Delay (RFC 8289), drop departing packets when the queuing delay stays above target for an interval.
+type codel struct {
+	fifo
+	target   time.Duration
+	interval time.Duration
+
+	maxPacket      int // the largest packet seen, a queue holding less than it has no standing delay
+	firstAboveTime time.Time
+	dropNext       time.Time
+	count          int
+	lastCount      int
+	dropping       bool
+}
+
+func (q *codel) enqueue(dt *dataWithTime, now time.Time) bool {
+	if len(dt.data) > q.maxPacket {
+		q.maxPacket = len(dt.data)
+	}
+	return q.fifo.enqueue(dt, now)
+}
+
+func (q *codel) controlLaw(t time.Time) time.Time {
+	return t.Add(time.Duration(float64(q.interval) / math.Sqrt(float64(q.count))))
+}
+
+// pop a packet and tell if its sojourn time has been above target for at least an interval
+func (q *codel) pop(now time.Time) (*dataWithTime, bool) {
+	dt := q.fifo.pop()
+	if dt == nil {
+		q.firstAboveTime = zeroTime
+		return nil, false
+	}
+
+	if now.Sub(dt.enqueued) < q.target || q.bytes <= q.maxPacket {
+		q.firstAboveTime = zeroTime
+		return dt, false
+	}
+	if q.firstAboveTime.IsZero() {
+		q.firstAboveTime = now.Add(q.interval)
+		return dt, false
+	}
+	return dt, !now.Before(q.firstAboveTime)
+}
+
+func (q *codel) dequeue(now time.Time) (*dataWithTime, []*dataWithTime) {
+	var dropped []*dataWithTime
+
+	dt, okToDrop := q.pop(now)
+	if dt == nil {
+		q.dropping = false
+		return nil, nil
+	}
+
+	if q.dropping {
+		if !okToDrop {
+			q.dropping = false
+		}
+		for q.dropping && !now.Before(q.dropNext) {
+			dropped = append(dropped, dt)
+			q.count++
+			dt, okToDrop = q.pop(now)
+			if !okToDrop {
+				q.dropping = false
+			} else {
+				q.dropNext = q.controlLaw(q.dropNext)
+			}
+		}
+	} else if okToDrop {
+		dropped = append(dropped, dt)
+		dt, _ = q.pop(now)
+		q.dropping = true
+		delta := q.count - q.lastCount
+		if delta > 1 && now.Sub(q.dropNext) < 16*q.interval {
+			q.count = delta
+		} else {
+			q.count = 1
+		}
+		q.dropNext = q.controlLaw(now)
+		q.lastCount = q.count
+	}
+
+	return dt, dropped
+}
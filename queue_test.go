@@ -0,0 +1,102 @@
+package mockconn
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestDropTail
+func TestDropTail(t *testing.T) {
+	now := time.Now()
+	q := newQueue(&QueueConfig{Discipline: QueueDropTail, Limit: 4, ByteLimit: 2048}, 100)
+
+	require.True(t, q.enqueue(&dataWithTime{data: make([]byte, 1024)}, now))
+	require.True(t, q.enqueue(&dataWithTime{data: make([]byte, 1024)}, now))
+	require.False(t, q.enqueue(&dataWithTime{data: make([]byte, 1)}, now)) // byte limit
+	require.Equal(t, 2, q.len())
+
+	dt, dropped := q.dequeue(now)
+	require.NotNil(t, dt)
+	require.Empty(t, dropped)
+
+	q = newQueue(&QueueConfig{Discipline: QueueDropTail}, 3)
+	for i := 0; i < 3; i++ {
+		require.True(t, q.enqueue(&dataWithTime{data: []byte("hello")}, now))
+	}
+	require.False(t, q.enqueue(&dataWithTime{data: []byte("hello")}, now)) // packet limit is BufferSize
+}
+
+// go test -v -run=TestRED
+func TestRED(t *testing.T) {
+	now := time.Now()
+	q := newQueue(&QueueConfig{Discipline: QueueRED, Limit: 100, Weight: 0.5}, 0)
+
+	nDrop := 0
+	for i := 0; i < 100; i++ {
+		if !q.enqueue(&dataWithTime{data: []byte("hello")}, now) {
+			nDrop++
+		}
+	}
+	// average queue length follows the queue quickly with weight 0.5, RED drops long before the queue is full.
+	require.Greater(t, nDrop, 0)
+	require.Less(t, q.len(), 80)
+}
+
+// go test -v -run=TestCoDel
+func TestCoDel(t *testing.T) {
+	now := time.Now()
+	q := newQueue(&QueueConfig{Discipline: QueueCoDel, Limit: 1000}, 0)
+
+	// packets arrive twice as fast as they leave, queuing delay keeps growing
+	nDrop := 0
+	for i := 0; i < 1000; i++ {
+		now = now.Add(time.Millisecond)
+		q.enqueue(&dataWithTime{data: make([]byte, 1024)}, now)
+		if i%2 == 0 {
+			_, dropped := q.dequeue(now)
+			nDrop += len(dropped)
+		}
+	}
+	require.Greater(t, nDrop, 0)
+
+	// no drop when packets leave as fast as they arrive
+	q = newQueue(&QueueConfig{Discipline: QueueCoDel, Limit: 1000}, 0)
+	for i := 0; i < 1000; i++ {
+		now = now.Add(time.Millisecond)
+		q.enqueue(&dataWithTime{data: make([]byte, 1024)}, now)
+		dt, dropped := q.dequeue(now)
+		require.NotNil(t, dt)
+		require.Empty(t, dropped)
+	}
+}
+
+// go test -v -run=TestQueueDrop
+func TestQueueDrop(t *testing.T) {
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(100), Latency: 20 * time.Millisecond,
+		Queue: QueueConfig{Discipline: QueueDropTail, Limit: 10}}
+	uc, err := NewUniConn(conf)
+	require.Nil(t, err)
+	require.NotNil(t, uc)
+
+	// writer is not held back by the link, packets exceed the queue are dropped
+	start := time.Now()
+	b := make([]byte, 1024)
+	for i := 0; i < 100; i++ {
+		n, err := uc.Write(b)
+		require.Nil(t, err)
+		require.Equal(t, len(b), n)
+	}
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+
+	m := uc.Metrics()
+	require.Greater(t, m.QueueDrop, int64(80))
+	require.Equal(t, int64(0), m.Loss)
+
+	uc.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := uc.Read(b)
+	require.Nil(t, err)
+	require.Equal(t, len(b), n)
+	uc.Close()
+}
@@ -0,0 +1,148 @@
+package mockconn
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Run with race detector: go test -race -run=TestConcurrent
+
+// go test -v -run=TestConcurrentReadWrite
+func TestConcurrentReadWrite(t *testing.T) {
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(2000), Latency: 5 * time.Millisecond}
+	uc, err := NewUniConn(conf)
+	require.Nil(t, err)
+
+	nWriter, nPacket := 4, 50
+	var wgWrite, wgRead sync.WaitGroup
+	for i := 0; i < nWriter; i++ {
+		wgWrite.Add(1)
+		go func() {
+			defer wgWrite.Done()
+			b := make([]byte, 100)
+			for j := 0; j < nPacket; j++ {
+				_, err := uc.Write(b)
+				require.Nil(t, err)
+			}
+		}()
+	}
+
+	var nRead int64
+	for i := 0; i < 4; i++ {
+		wgRead.Add(1)
+		go func() {
+			defer wgRead.Done()
+			b := make([]byte, 64) // smaller than packets to share unread data between readers
+			for {
+				n, err := uc.Read(b)
+				if err != nil {
+					return
+				}
+				atomic.AddInt64(&nRead, int64(n))
+			}
+		}()
+	}
+
+	wgWrite.Wait()
+	uc.CloseWrite()
+	wgRead.Wait()
+
+	require.Equal(t, int64(nWriter*nPacket*100), nRead)
+	m := uc.Metrics()
+	require.Equal(t, int64(nWriter*nPacket), m.SendPacket)
+	require.Equal(t, int64(nWriter*nPacket), m.RecvPacket)
+}
+
+// go test -v -run=TestConcurrentDeadline
+func TestConcurrentDeadline(t *testing.T) {
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(1000), Latency: 5 * time.Millisecond}
+	uc, err := NewUniConn(conf)
+	require.Nil(t, err)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	run := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					f()
+				}
+			}
+		}()
+	}
+
+	b1, b2 := make([]byte, 1024), make([]byte, 1024)
+	run(func() { uc.Write(b1) })
+	run(func() { uc.Read(b2) })
+	run(func() { uc.SetDeadline(time.Now().Add(time.Millisecond)) })
+	run(func() { uc.SetReadDeadline(zeroTime) })
+	run(func() { uc.SetWriteDeadline(zeroTime) })
+	run(func() { uc.Metrics() })
+
+	time.Sleep(200 * time.Millisecond)
+	close(done)
+	uc.Close()
+	wg.Wait()
+}
+
+// go test -v -run=TestConcurrentNetConn
+func TestConcurrentNetConn(t *testing.T) {
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(1000), Latency: 5 * time.Millisecond}
+	aliceConn, bobConn, err := NewMockConn(conf)
+	require.Nil(t, err)
+	alice, bob := aliceConn.(*NetConn), bobConn.(*NetConn)
+
+	var wg sync.WaitGroup
+	wg.Add(5)
+	go func() {
+		defer wg.Done()
+		b := make([]byte, 1024)
+		for i := 0; i < 50; i++ {
+			alice.Write(b)
+		}
+		alice.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		b := make([]byte, 1024)
+		for {
+			if _, err := bob.Read(b); err != nil && err != ErrReadPaused {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			alice.PauseWrite()
+			bob.PauseRead()
+			alice.ResumeWrite()
+			bob.ResumeRead()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			alice.SetDeadline(time.Now().Add(time.Second))
+			bob.SetReadDeadline(time.Now().Add(time.Second))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			alice.Metrics()
+			bob.Metrics()
+		}
+	}()
+	wg.Wait()
+	bob.Close()
+}
@@ -0,0 +1,6 @@
+//go:build race
+
+package mockconn
+
+// raceEnabled tells if the tests run with the race detector, which slows down the goroutines and timers.
+const raceEnabled = true
@@ -0,0 +1,241 @@
+package mockconn
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrBadRecord      error = errors.New("not a mockconn session record")
+	ErrUnknownSide    error = errors.New("address is not a side of the session")
+	ErrReplayMismatch error = errors.New("peer data differs from the session record")
+)
+
+// A session record starts with recordMagic and the addresses of the two sides, each as uvarint length and bytes.
+// Then every write is a record of: the side writing (0 or 1), uvarint microseconds since the previous write,
+// uvarint length of data, and data.
+const recordMagic = "MCR1"
+
+// Session is a recorded session of a connection pair.
+type Session struct {
+	Addr   [2]string // addresses of the two sides
+	Writes []Write   // writes of both sides in the order they are done
+}
+
+// Write is a write recorded in a session.
+type Write struct {
+	From int           // the side writing, index of Addr
+	Time time.Duration // time since the session starts
+	Data []byte
+}
+
+// Recorder records writes of a connection pair to a session record.
+type Recorder struct {
+	mu    sync.Mutex
+	w     io.Writer
+	buf   []byte
+	start time.Time
+	last  time.Duration
+	err   error
+}
+
+// A connection recording its writes.
+type recordConn struct {
+	net.Conn
+	r    *Recorder
+	side byte
+}
+
+// NewRecorder writes the header of a session record to w, and returns the recorder and the two connections
+// wrapped to record every successful write, such as the endpoints from NewMockConn. The wrapped connections
+// are plain net.Conn, use the original ones for methods of NetConn.
+// Writes are recorded in the order they return, with the time they are called.
+func NewRecorder(w io.Writer, conn1, conn2 net.Conn) (*Recorder, net.Conn, net.Conn, error) {
+	r := &Recorder{w: w, start: time.Now()}
+
+	b := append(r.buf[:0], recordMagic...)
+	for _, conn := range []net.Conn{conn1, conn2} {
+		addr := conn.LocalAddr().String()
+		b = appendUvarint(b, uint64(len(addr)))
+		b = append(b, addr...)
+	}
+	r.buf = b
+	if _, err := w.Write(b); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return r, &recordConn{Conn: conn1, r: r, side: 0}, &recordConn{Conn: conn2, r: r, side: 1}, nil
+}
+
+// Err returns the first error writing records, writes are not recorded any more after it.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) record(side byte, t time.Time, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+
+	// writes returning out of order are recorded at the time of the previous write
+	d := t.Sub(r.start)
+	if d < r.last {
+		d = r.last
+	}
+	delta := (d - r.last) / time.Microsecond
+	r.last += delta * time.Microsecond
+
+	b := append(r.buf[:0], side)
+	b = appendUvarint(b, uint64(delta))
+	b = appendUvarint(b, uint64(len(data)))
+	b = append(b, data...)
+	r.buf = b
+	_, r.err = r.w.Write(b)
+}
+
+func (c *recordConn) Write(b []byte) (int, error) {
+	t := time.Now()
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.r.record(c.side, t, b[:n])
+	}
+	return n, err
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// ReadSession reads a session record.
+func ReadSession(r io.Reader) (*Session, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(recordMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != recordMagic {
+		return nil, ErrBadRecord
+	}
+
+	s := &Session{}
+	for i := range s.Addr {
+		b, err := readBytes(br)
+		if err != nil {
+			return nil, err
+		}
+		s.Addr[i] = string(b)
+	}
+
+	var t time.Duration
+	for {
+		side, err := br.ReadByte()
+		if err == io.EOF {
+			return s, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if side > 1 {
+			return nil, ErrBadRecord
+		}
+		delta, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, ErrBadRecord
+		}
+		data, err := readBytes(br)
+		if err != nil {
+			return nil, err
+		}
+		t += time.Duration(delta) * time.Microsecond
+		s.Writes = append(s.Writes, Write{From: int(side), Time: t, Data: data})
+	}
+}
+
+// read uvarint length and bytes, the buffer grows with the bytes read so a corrupt length can not allocate
+// more than the record holds
+func readBytes(br *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil || n > math.MaxInt64 {
+		return nil, ErrBadRecord
+	}
+	var b bytes.Buffer
+	if _, err := io.CopyN(&b, br, int64(n)); err != nil {
+		return nil, ErrBadRecord
+	}
+	return b.Bytes(), nil
+}
+
+// Replay plays the side of addr in the session on conn against a peer on the other end. The recorded writes
+// of addr are written in order, and before each of them the data the other side wrote earlier in the record
+// is read from conn and compared, ErrReplayMismatch is returned if the peer sends something else.
+// If timed is true, writes are not done earlier than their recorded time since Replay starts.
+// After all writes, Replay reads and checks the rest of the peer data.
+func (s *Session) Replay(conn net.Conn, addr string, timed bool) error {
+	side := -1
+	for i := range s.Addr {
+		if s.Addr[i] == addr {
+			side = i
+		}
+	}
+	if side < 0 {
+		return ErrUnknownSide
+	}
+
+	start := time.Now()
+	var expected []byte // peer data not read yet
+	var offset int      // peer data read
+	check := func() error {
+		if len(expected) == 0 {
+			return nil
+		}
+		b := make([]byte, len(expected))
+		n, err := io.ReadFull(conn, b)
+		if i := mismatch(b[:n], expected); i >= 0 {
+			return fmt.Errorf("%w: byte %v from %v", ErrReplayMismatch, offset+i, s.Addr[1-side])
+		}
+		if err != nil {
+			return err
+		}
+		offset += n
+		expected = expected[:0]
+		return nil
+	}
+
+	for _, w := range s.Writes {
+		if w.From != side {
+			expected = append(expected, w.Data...)
+			continue
+		}
+		if err := check(); err != nil {
+			return err
+		}
+		if timed {
+			time.Sleep(time.Until(start.Add(w.Time)))
+		}
+		if _, err := conn.Write(w.Data); err != nil {
+			return err
+		}
+	}
+	return check()
+}
+
+// mismatch returns the index of the first byte of b different from expected, -1 if b is a prefix of expected.
+func mismatch(b, expected []byte) int {
+	for i := range b {
+		if b[i] != expected[i] {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,98 @@
+package mockconn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// a server replying "pong n" to "ping n" until the connection is closed, upper replies in upper case
+func pongServer(conn net.Conn, upper bool) {
+	b := make([]byte, 1024)
+	for {
+		n, err := conn.Read(b)
+		if err != nil {
+			return
+		}
+		reply := bytes.Replace(b[:n], []byte("ping"), []byte("pong"), 1)
+		if upper {
+			reply = bytes.ToUpper(reply)
+		}
+		conn.Write(reply)
+	}
+}
+
+// go test -v -run=TestRecordReplay
+func TestRecordReplay(t *testing.T) {
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(1000), Latency: 5 * time.Millisecond}
+	alice, bob, err := NewMockConn(conf)
+	require.Nil(t, err)
+
+	var record bytes.Buffer
+	r, alice, bob, err := NewRecorder(&record, alice, bob)
+	require.Nil(t, err)
+
+	go pongServer(bob, false)
+	b := make([]byte, 1024)
+	for _, ping := range []string{"ping 1", "ping 2", "ping 3"} {
+		_, err = alice.Write([]byte(ping))
+		require.Nil(t, err)
+		_, err = io.ReadFull(alice, b[:len(ping)])
+		require.Nil(t, err)
+	}
+	alice.Close()
+	bob.Close()
+	require.Nil(t, r.Err())
+
+	s, err := ReadSession(&record)
+	require.Nil(t, err)
+	require.Equal(t, [2]string{"Alice", "Bob"}, s.Addr)
+	require.Len(t, s.Writes, 6)
+	for i, w := range s.Writes {
+		require.Equal(t, i%2, w.From)
+		if i > 0 {
+			require.GreaterOrEqual(t, w.Time, s.Writes[i-1].Time+conf.Latency)
+		}
+	}
+	require.Equal(t, "pong 3", string(s.Writes[5].Data))
+
+	// replay Alice against a new server
+	for _, upper := range []bool{false, true} {
+		alice, bob, err := NewMockConn(conf)
+		require.Nil(t, err)
+		go pongServer(bob, upper)
+
+		err = s.Replay(alice, "Alice", true)
+		if upper {
+			require.True(t, errors.Is(err, ErrReplayMismatch))
+		} else {
+			require.Nil(t, err)
+		}
+		alice.Close()
+		bob.Close()
+	}
+
+	require.ErrorIs(t, s.Replay(alice, "Carol", false), ErrUnknownSide)
+	_, err = ReadSession(bytes.NewReader([]byte("hello")))
+	require.ErrorIs(t, err, ErrBadRecord)
+}
+
+// go test -v -run=TestReadCorruptSession
+func TestReadCorruptSession(t *testing.T) {
+	huge := binary.AppendUvarint(nil, math.MaxUint64)
+	for _, record := range [][]byte{
+		append([]byte(recordMagic), huge...),                              // length of an address
+		append([]byte(recordMagic+"\x05Alice\x03Bob\x00\x01"), huge...),   // length of a write
+		append([]byte(recordMagic+"\x05Alice\x03Bob\x00\x01\xff\xff"), 0), // length longer than the record
+	} {
+		_, err := ReadSession(bytes.NewReader(record))
+		require.ErrorIs(t, err, ErrBadRecord)
+	}
+}
@@ -0,0 +1,246 @@
+package mockconn
+
+import (
+	"container/heap"
+	"runtime"
+	"sync"
+	"time"
+)
+
+var (
+	defaultScheduler     *Scheduler
+	defaultSchedulerOnce sync.Once
+)
+
+// A scheduler without events for schedulerIdle stops its goroutines, they start again by the next event.
+const schedulerIdle = 100 * time.Millisecond
+
+// Scheduler runs the timed events of many connections, such as a packet arriving after latency or
+// the link being ready for the next packet. It uses one timer goroutine and a fixed pool of workers
+// however many connections share it, so there is no goroutine or timer per connection or packet.
+// The goroutines run only while there are events, they exit after the scheduler is idle for a while.
+type Scheduler struct {
+	workers int
+
+	mu      sync.Mutex // protect the fields below
+	events  eventHeap
+	running bool // the goroutines are running
+
+	wakeCh chan struct{} // notify the timer goroutine the earliest event is changed
+	workCh chan func()   // due events for workers
+	doneCh chan struct{}
+	once   sync.Once
+}
+
+// A function to run at a time.
+type event struct {
+	at    time.Time
+	owner interface{} // the events of an owner can be canceled, nil if they can not
+	f     func()
+}
+
+type eventHeap []event
+
+func (h eventHeap) Len() int            { return len(h) }
+func (h eventHeap) Less(i, j int) bool  { return h[i].at.Before(h[j].at) }
+func (h eventHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *eventHeap) Push(x interface{}) { *h = append(*h, x.(event)) }
+func (h *eventHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = event{}
+	*h = old[:len(old)-1]
+	return e
+}
+
+// push and pop are heap.Push and heap.Pop without boxing the event in an interface, which would allocate.
+func (h *eventHeap) push(e event) {
+	*h = append(*h, e)
+	heap.Fix(h, len(*h)-1)
+}
+
+func (h *eventHeap) pop() event {
+	n := len(*h) - 1
+	h.Swap(0, n)
+	e := (*h)[n]
+	(*h)[n] = event{}
+	*h = (*h)[:n]
+	if n > 0 {
+		heap.Fix(h, 0)
+	}
+	return e
+}
+
+// NewScheduler creates a scheduler with the number of workers, GOMAXPROCS workers are used if it is not positive.
+func NewScheduler(workers int) *Scheduler {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	return &Scheduler{workers: workers, wakeCh: make(chan struct{}, 1), workCh: make(chan func(), workers),
+		doneCh: make(chan struct{})}
+}
+
+// The scheduler shared by connections which have no Scheduler in ConnConfig.
+func getDefaultScheduler() *Scheduler {
+	defaultSchedulerOnce.Do(func() {
+		defaultScheduler = NewScheduler(0)
+	})
+	return defaultScheduler
+}
+
+// Close stops the scheduler, pending events and events scheduled afterwards are discarded. Calls of connections
+// using it which would wait for the link or packets in flight fail with net.ErrClosed.
+func (s *Scheduler) Close() error {
+	s.once.Do(func() {
+		close(s.doneCh)
+	})
+	return nil
+}
+
+// schedule runs f by a worker at time t. f should be short and not block. f is discarded if s is closed, or if
+// cancel is called with the same owner before t.
+func (s *Scheduler) schedule(t time.Time, owner interface{}, f func()) {
+	s.mu.Lock()
+	if s.closed() {
+		s.mu.Unlock()
+		return
+	}
+	s.events.push(event{at: t, owner: owner, f: f})
+	earliest := s.events[0].at.Equal(t)
+	if !s.running {
+		s.running = true
+		idleCh := make(chan struct{})
+		go s.run(idleCh)
+		for i := 0; i < s.workers; i++ {
+			go s.work(idleCh)
+		}
+	}
+	s.mu.Unlock()
+
+	if earliest {
+		select {
+		case s.wakeCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// cancel discards the pending events of owner, such as a closed connection, so they neither keep the goroutines
+// running nor keep owner from being garbage collected until they are due.
+func (s *Scheduler) cancel(owner interface{}) {
+	s.mu.Lock()
+	events := s.events[:0]
+	for _, e := range s.events {
+		if e.owner != owner {
+			events = append(events, e)
+		}
+	}
+	for i := len(events); i < len(s.events); i++ {
+		s.events[i] = event{}
+	}
+	canceled := len(events) < len(s.events)
+	s.events = events
+	heap.Init(&s.events)
+	s.mu.Unlock()
+
+	// the timer goroutine waits for the earliest event, which may be gone
+	if canceled {
+		select {
+		case s.wakeCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// The timer goroutine hands due events to workers. It closes idleCh and exits when there has been no event
+// for schedulerIdle.
+func (s *Scheduler) run(idleCh chan struct{}) {
+	defer close(idleCh)
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	var due []func()
+	for {
+		s.mu.Lock()
+		now := time.Now()
+		for len(s.events) > 0 && !s.events[0].at.After(now) {
+			due = append(due, s.events.pop().f)
+		}
+		wait, idle := schedulerIdle, true
+		if len(s.events) > 0 {
+			wait, idle = s.events[0].at.Sub(now), false
+		}
+		s.mu.Unlock()
+
+		for i, f := range due {
+			select {
+			case s.workCh <- f:
+			case <-s.doneCh:
+				return
+			}
+			due[i] = nil
+		}
+		due = due[:0]
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-timer.C:
+			if idle && s.stopIdle() {
+				return
+			}
+		case <-s.wakeCh:
+		case <-s.doneCh:
+			return
+		}
+	}
+}
+
+// closed tells if Close has been called.
+func (s *Scheduler) closed() bool {
+	select {
+	case <-s.doneCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// stopIdle tells the goroutines to stop if there is still no event.
+func (s *Scheduler) stopIdle() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.events) > 0 {
+		return false
+	}
+	s.running = false
+	return true
+}
+
+func (s *Scheduler) work(idleCh chan struct{}) {
+	for {
+		select {
+		case f := <-s.workCh:
+			f()
+		case <-idleCh:
+			// run the events handed over before the timer goroutine stops
+			for {
+				select {
+				case f := <-s.workCh:
+					f()
+				default:
+					return
+				}
+			}
+		case <-s.doneCh:
+			return
+		}
+	}
+}
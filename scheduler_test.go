@@ -0,0 +1,171 @@
+package mockconn
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestScheduler
+func TestScheduler(t *testing.T) {
+	s := NewScheduler(2)
+	defer s.Close()
+
+	start := time.Now()
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for _, i := range []int{3, 1, 2} {
+		i := i
+		wg.Add(1)
+		s.schedule(start.Add(time.Duration(i)*20*time.Millisecond), nil, func() {
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			wg.Done()
+		})
+	}
+	wg.Wait()
+
+	require.Equal(t, []int{1, 2, 3}, order)
+	require.GreaterOrEqual(t, time.Since(start), 60*time.Millisecond)
+}
+
+// go test -v -run=TestSchedulerGoroutines
+func TestSchedulerGoroutines(t *testing.T) {
+	s := NewScheduler(4)
+	defer s.Close()
+	nGoroutine := runtime.NumGoroutine()
+
+	var conns []*UniConn
+	for i := 0; i < 1000; i++ {
+		conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(100), Latency: 10 * time.Millisecond, Scheduler: s}
+		uc, err := NewUniConn(conf)
+		require.Nil(t, err)
+		conns = append(conns, uc)
+	}
+	// goroutines of earlier tests may still be exiting, so no more than before rather than as many
+	waitGoroutines(t, nGoroutine)
+
+	b := make([]byte, 1024)
+	for _, uc := range conns {
+		_, err := uc.Write(b)
+		require.Nil(t, err)
+	}
+	for _, uc := range conns {
+		n, err := uc.Read(b)
+		require.Nil(t, err)
+		require.Equal(t, len(b), n)
+		uc.Close()
+	}
+}
+
+// go test -bench=BenchmarkConns -run=^$
+func BenchmarkConns(b *testing.B) {
+	for _, nConn := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprintf("conns=%v", nConn), func(b *testing.B) {
+			s := NewScheduler(0)
+			defer s.Close()
+
+			conns := make([]*UniConn, nConn)
+			for i := range conns {
+				conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Latency: time.Millisecond, Scheduler: s}
+				conns[i], _ = NewUniConn(conf)
+			}
+			nGoroutine := runtime.NumGoroutine()
+
+			// every connection sends a packet and reads it after latency
+			nWorker := runtime.GOMAXPROCS(0)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var wg sync.WaitGroup
+				for w := 0; w < nWorker; w++ {
+					wg.Add(1)
+					go func(w int) {
+						defer wg.Done()
+						buf := make([]byte, 64)
+						for j := w; j < nConn; j += nWorker {
+							conns[j].Write(buf)
+						}
+						for j := w; j < nConn; j += nWorker {
+							conns[j].Read(buf)
+						}
+					}(w)
+				}
+				wg.Wait()
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(nGoroutine), "goroutines")
+
+			for _, uc := range conns {
+				uc.Close()
+			}
+		})
+	}
+}
+
+// go test -v -run=TestSchedulerIdle
+func TestSchedulerIdle(t *testing.T) {
+	s := NewScheduler(2)
+	defer s.Close()
+	nGoroutine := runtime.NumGoroutine()
+
+	// goroutines run while there are events and start again by the next event
+	for i := 0; i < 2; i++ {
+		done := make(chan struct{})
+		s.schedule(time.Now().Add(10*time.Millisecond), nil, func() { close(done) })
+		require.True(t, s.isRunning())
+		<-done
+		for deadline := time.Now().Add(time.Second); s.isRunning(); time.Sleep(10 * time.Millisecond) {
+			require.True(t, time.Now().Before(deadline), "scheduler is not stopped when idle")
+		}
+		waitGoroutines(t, nGoroutine)
+	}
+}
+
+// go test -v -run=TestSchedulerClose
+func TestSchedulerClose(t *testing.T) {
+	s := NewScheduler(2)
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(10), Latency: 10 * time.Millisecond, Scheduler: s}
+	uc, err := NewUniConn(conf)
+	require.Nil(t, err)
+	defer uc.Close()
+	nGoroutine := runtime.NumGoroutine()
+
+	// a writer waiting for the link and a reader waiting for packets in flight fail when the scheduler is closed
+	_, err = uc.Write([]byte("hello"))
+	require.Nil(t, err)
+	writeErr := make(chan error)
+	go func() {
+		_, err := uc.Write([]byte("hello"))
+		writeErr <- err
+	}()
+	readErr := make(chan error)
+	go func() {
+		b := make([]byte, 1024)
+		_, err := uc.Read(b)
+		readErr <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+	s.Close()
+	require.ErrorIs(t, <-writeErr, net.ErrClosed)
+	require.ErrorIs(t, <-readErr, net.ErrClosed)
+
+	// events scheduled after Close are discarded without goroutines
+	_, err = uc.Write([]byte("hello"))
+	require.ErrorIs(t, err, net.ErrClosed)
+	s.schedule(time.Now(), nil, func() { t.Error("event run after Close") })
+	waitGoroutines(t, nGoroutine)
+	time.Sleep(10 * time.Millisecond)
+}
+
+func (s *Scheduler) isRunning() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running
+}
@@ -0,0 +1,118 @@
+package mockconn
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"time"
+)
+
+// ErrTLSConfig is returned by NewTLSConn if only one of the configs is nil, a generated config of one side would
+// not match the config given for the other.
+var ErrTLSConfig error = errors.New("either both or none of the TLS configs should be nil")
+
+// NewTLSConfig generates a self-signed certificate for host in memory, and returns a server config presenting it
+// and a client config trusting it with ServerName host. host may be a DNS name or an IP address.
+func NewTLSConfig(host string) (serverConf, clientConf *tls.Config, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"mockconn"}, CommonName: host},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	serverConf = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}}}
+	clientConf = &tls.Config{RootCAs: pool, ServerName: host}
+	return serverConf, clientConf, nil
+}
+
+// NewTLSConn mocks a connection like NewMockConn and runs a TLS handshake over it, Addr1 is the client and Addr2
+// the server. If serverConf and clientConf are nil, they are generated by NewTLSConfig for the host of Addr2,
+// ErrTLSConfig is returned if only one of them is nil.
+// It returns after both endpoints finish the handshake, or when ctx is done, then both are closed and the
+// error of the handshake is returned.
+//
+// handshake is the time the client takes to finish the handshake, it is 2 * Latency for TLS 1.3 and 4 * Latency
+// for TLS 1.2 plus the time to transmit and compute. The server finishes Latency later.
+func NewTLSConn(ctx context.Context, conf *ConnConfig, serverConf, clientConf *tls.Config) (
+	client, server *tls.Conn, handshake time.Duration, err error) {
+	if (serverConf == nil) != (clientConf == nil) {
+		return nil, nil, 0, ErrTLSConfig
+	}
+	if serverConf == nil {
+		host, _, err := net.SplitHostPort(conf.Addr2)
+		if err != nil {
+			host = conf.Addr2
+		}
+		serverConf, clientConf, err = NewTLSConfig(host)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+	}
+
+	clientConn, serverConn, err := NewMockConn(conf)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	client = tls.Client(clientConn, clientConf)
+	server = tls.Server(serverConn, serverConf)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.HandshakeContext(ctx)
+	}()
+
+	start := time.Now()
+	err = client.HandshakeContext(ctx)
+	handshake = time.Since(start)
+	if err != nil {
+		// the server may be waiting for the client
+		clientConn.Close()
+		serverConn.Close()
+		<-serverErr
+		return nil, nil, handshake, err
+	}
+	if err = <-serverErr; err != nil {
+		clientConn.Close()
+		serverConn.Close()
+		return nil, nil, handshake, err
+	}
+	return client, server, handshake, nil
+}
@@ -0,0 +1,77 @@
+package mockconn
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestNewTLSConn
+func TestNewTLSConn(t *testing.T) {
+	latency := 20 * time.Millisecond
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob:443", Latency: latency}
+
+	for _, tc := range []struct {
+		version    uint16
+		roundTrips time.Duration
+	}{
+		{tls.VersionTLS13, 1},
+		{tls.VersionTLS12, 2},
+	} {
+		serverConf, clientConf, err := NewTLSConfig("Bob")
+		require.Nil(t, err)
+		clientConf.MaxVersion = tc.version
+
+		client, server, handshake, err := NewTLSConn(context.Background(), conf, serverConf, clientConf)
+		require.Nil(t, err)
+		require.Equal(t, tc.version, client.ConnectionState().Version)
+		require.GreaterOrEqual(t, handshake, 2*latency*tc.roundTrips)
+		require.Less(t, handshake, 2*latency*tc.roundTrips+latency)
+
+		_, err = client.Write([]byte("hello"))
+		require.Nil(t, err)
+		b := make([]byte, 1024)
+		n, err := server.Read(b)
+		require.Nil(t, err)
+		require.Equal(t, "hello", string(b[:n]))
+
+		client.Close()
+		server.Close()
+	}
+}
+
+// go test -v -run=TestTLSHandshakeTimeout
+func TestTLSHandshakeTimeout(t *testing.T) {
+	// every packet is lost
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Latency: 10 * time.Millisecond, Loss: 1, BufferSize: 100}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, _, err := NewTLSConn(ctx, conf, nil, nil)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	// a certificate generated by another call is not trusted
+	serverConf, _, err := NewTLSConfig("Bob")
+	require.Nil(t, err)
+	_, clientConf, err := NewTLSConfig("Bob")
+	require.Nil(t, err)
+	conf.Loss = 0
+	_, _, _, err = NewTLSConn(context.Background(), conf, serverConf, clientConf)
+	require.NotNil(t, err)
+}
+
+// go test -v -run=TestNewTLSConnOneConfig
+func TestNewTLSConnOneConfig(t *testing.T) {
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Latency: 10 * time.Millisecond}
+	serverConf, clientConf, err := NewTLSConfig("Bob")
+	require.Nil(t, err)
+
+	_, _, _, err = NewTLSConn(context.Background(), conf, nil, clientConf)
+	require.ErrorIs(t, err, ErrTLSConfig)
+	_, _, _, err = NewTLSConn(context.Background(), conf, serverConf, nil)
+	require.ErrorIs(t, err, ErrTLSConfig)
+}
@@ -4,26 +4,37 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
-	"math/rand"
+	"io"
+	"log/slog"
 	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
-
-	"golang.org/x/time/rate"
 )
 
 var (
 	zeroTime      time.Time
-	ErrClosedConn error = errors.New("connection is closed")
+	ErrClosedConn error = net.ErrClosed
 	ErrNilPointer error = errors.New("data pointer is nil")
 	ErrZeroLengh  error = errors.New("zero length data to write")
 	ErrUnknown    error = errors.New("UniConn unknown error")
+	ErrConnReset  error = syscall.ECONNRESET // connection is aborted by Reset
 )
 
+// Timers and goroutines of the host may run several milliseconds late, the link catches up on the time lost
+// within maxLinkLag.
+const maxLinkLag = 10 * time.Millisecond
+
 // To trace time consuming.
 type dataWithTime struct {
-	data []byte
-	t    time.Time
+	data     []byte
+	t        time.Time // time to pass the link
+	due      time.Time // time it can be read, after latency and the delay of interceptors
+	enqueued time.Time // time to enter the bottleneck queue
+	seq      uint64    // order to pass the link
+	pkt      Packet    // the packet seen by interceptors
 }
 
 // unidirectional channel, can only send data from localAddr to remoteAddr
@@ -31,223 +42,530 @@ type UniConn struct {
 	localAddr  string
 	remoteAddr string
 
-	throughput   uint
-	bufferSize   uint
-	latency      time.Duration
-	loss         float32
 	writeTimeout time.Duration // default timeout for writing
+	nonBlocking  bool          // drop packets instead of waiting for the link
 	readTimeout  time.Duration // default timeout for reading
 
-	sendCh   chan *dataWithTime
-	bufferCh chan *dataWithTime
-	recvCh   chan *dataWithTime
-
-	unreadData []byte // save unread data
-
-	// for metrics
+	scheduler    *Scheduler    // runs the timed events of the connection
+	observers    []Observer    // notified of packet events
+	interceptors []Interceptor // see packets passing the link
+	logger       *slog.Logger  // logs events with endpoint attributes, nil if not logging
+
+	// events of the scheduler, method values are made once rather than on every schedule
+	wakeReaderFunc func()
+	wakeLinkFunc   func()
+	wakeDrainFunc  func()
+
+	mu            sync.Mutex      // protect the fields below
+	throughput    uint            // can be changed by SetThroughput
+	bufferSize    uint            // packets in flight, follows throughput and latency if defaultBuffer
+	defaultBuffer bool            // BufferSize is not set, the buffer is unbounded if throughput is unlimited
+	interval      time.Duration   // time for the link to send a packet, zero if throughput is unlimited
+	latency       time.Duration   // can be changed by SetLatency
+	loss          float32         // can be changed by SetLoss
+	queue         queue           // bottleneck queue, nil if writers are held back by the link
+	nextSend      time.Time       // time the link is ready for the next packet
+	linkWake      bool            // an event is scheduled to wake up the link at nextSend
+	inFlight      packetHeap      // packets passed the link, they can be read when they are due
+	unreadData    []byte          // save unread data
+	unreadDt      *dataWithTime   // the packet holding unreadData, returned to pool after unreadData is read
+	intercepted   [2][]*Packet    // buffers of packets passing the interceptors
+	interceptedDt []*dataWithTime // buffer of packets out of the interceptors
+	readable      signal          // there may be a packet to read
+	writable      signal          // the link may take a packet
+	taken         signal          // the reader has taken a packet
+	writeFrozen   bool            // writes wait without progress, set by a freeze fault
+	readFrozen    bool            // reads wait without progress, set by a freeze fault
+	blackhole     bool            // packets passing the link are swallowed, set by a blackhole fault
+	partitioned   bool            // packets passing the link are swallowed, set by a chaos partition
+	spikeLatency  time.Duration   // added to latency, set by a chaos latency spike
+	burstLoss     float32         // loss rate instead of loss if not zero, set by a chaos loss burst
+	drainRate     int             // bytes per second the reader can take, zero if not limited
+	drainNext     time.Time       // time the reader can take the next packet if drainRate is set
+	drainWake     bool            // an event is scheduled to wake up the reader at drainNext
+	writerGone    bool            // the writer vanished, the reader never gets io.EOF
+	readerGone    bool            // the reader vanished, packets stay in flight and fill the buffer
+
+	// for metrics, counters are accessed atomically
 	nSendPacket    int64         // number of packets sent
 	nRecvPacket    int64         // number of packets received
 	nLoss          int64         // number of packets are random lost
-	averageLatency time.Duration // average latency of all packets
+	nQueueDrop     int64         // number of packets are dropped by the bottleneck queue
+	nWriteDrop     int64         // number of packets are dropped by non-blocking write
+	averageLatency time.Duration // average latency of all packets, protected by mu
 
-	// one time deadline and cancel
-	readCtx     context.Context
-	readCancel  context.CancelFunc
-	writeCtx    context.Context
-	writeCancel context.CancelFunc
+	// deadline can be changed while reading or writing
+	readDeadline  *deadline
+	writeDeadline *deadline
 
 	// close UniConn
 	closeWriteCtx       context.Context
 	closeWriteCtxCancel context.CancelFunc
 	closeReadCtx        context.Context
 	closeReadCtxCancel  context.CancelFunc
-}
-
-func init() {
-	rand.Seed(time.Now().UnixNano())
+	closeOnce           sync.Once // notify observers of close once
+	reset               int32     // set to 1 if the connection is aborted by Reset
+	graceful            int32     // set to 1 if closed by CloseGracefully after all packets are read
 }
 
 func NewUniConn(conf *ConnConfig) (*UniConn, error) {
-	bufferSize := conf.BufferSize
-	if bufferSize == 0 {
-		bufferSize = uint(2 * float64(conf.Throughput) * conf.Latency.Seconds())
-	}
+	uc := &UniConn{throughput: conf.Throughput, bufferSize: conf.BufferSize, defaultBuffer: conf.BufferSize == 0,
+		latency: conf.Latency, loss: conf.Loss,
+		writeTimeout: conf.WriteTimeout, readTimeout: conf.ReadTimeout, nonBlocking: conf.NonBlocking,
+		scheduler: conf.Scheduler, localAddr: conf.Addr1, remoteAddr: conf.Addr2}
 
-	uc := &UniConn{throughput: conf.Throughput, bufferSize: bufferSize, latency: conf.Latency, loss: conf.Loss,
-		writeTimeout: conf.WriteTimeout, readTimeout: conf.ReadTimeout,
-		sendCh: make(chan *dataWithTime), bufferCh: make(chan *dataWithTime, bufferSize),
-		recvCh: make(chan *dataWithTime), localAddr: conf.Addr1, remoteAddr: conf.Addr2}
+	if uc.scheduler == nil {
+		uc.scheduler = getDefaultScheduler()
+	}
+	uc.wakeReaderFunc, uc.wakeLinkFunc, uc.wakeDrainFunc = uc.wakeReader, uc.wakeLink, uc.wakeDrain
+	if conf.Logger != nil {
+		uc.logger = conf.Logger.With("local", uc.localAddr, "remote", uc.remoteAddr)
+	}
+	uc.interceptors = append(uc.interceptors, conf.Interceptors...)
+	uc.observers = append(uc.observers, conf.Observers...)
+	if conf.Throughput > 0 {
+		uc.interval = time.Second / time.Duration(conf.Throughput)
+	}
+	uc.updateBuffer()
+	if conf.Queue.Discipline != QueueNone {
+		uc.queue = newQueue(&conf.Queue, uc.bufferSize)
+	}
 
 	uc.closeWriteCtx, uc.closeWriteCtxCancel = context.WithCancel(context.Background())
 	uc.closeReadCtx, uc.closeReadCtxCancel = context.WithCancel(context.Background())
-	uc.SetDeadline(zeroTime)
+	uc.readDeadline = newDeadline(uc.closeReadCtx)
+	uc.writeDeadline = newDeadline(uc.closeWriteCtx)
 
-	go uc.throughputRead()
-	go uc.latencyRead()
+	uc.log(slog.LevelInfo, "connection opened", "throughput", conf.Throughput, "bufferSize", uc.bufferSize,
+		"latency", conf.Latency, "loss", conf.Loss, "queue", conf.Queue.Discipline)
 
 	return uc, nil
 }
 
 func (uc *UniConn) Write(b []byte) (n int, err error) {
-	if err = uc.writeCtx.Err(); err != nil {
+	return uc.WriteContext(context.Background(), b)
+}
+
+// WriteContext writes like Write, and returns ctx.Err() if ctx is done before the link takes the data.
+func (uc *UniConn) WriteContext(ctx context.Context, b []byte) (n int, err error) {
+	defer func() { err = uc.opError("write", err) }()
+
+	if err = ctx.Err(); err != nil {
 		return 0, err
 	}
+	if err = uc.writeDeadline.expired(); err != nil {
+		return 0, uc.ctxErr(err)
+	}
+	if uc.closeReadCtx.Err() != nil { // nobody will read it
+		return 0, ErrConnReset
+	}
+	if uc.scheduler.closed() { // the packet would never arrive
+		return 0, net.ErrClosed
+	}
 
 	if len(b) == 0 {
 		return 0, ErrZeroLengh
 	}
 
-	var timeoutCtx context.Context
-	var timeoutCancel context.CancelFunc
-	if uc.writeTimeout > 0 {
-		timeoutCtx, timeoutCancel = context.WithTimeout(uc.writeCtx, uc.writeTimeout)
-	} else {
-		timeoutCtx, timeoutCancel = context.WithCancel(uc.writeCtx)
-	}
+	timeoutCtx, timeoutCancel := withTimeout(uc.writeTimeout)
 	defer timeoutCancel()
+	if err = uc.waitThawed(ctx, timeoutCtx); err != nil {
+		return 0, err
+	}
 
-	dt := &dataWithTime{data: b}
-	select {
-	case uc.sendCh <- dt:
-		uc.nSendPacket++
+	// copy data to a pooled buffer, caller may reuse b after Write returns
+	dt := getPacket(b)
 
-	case <-timeoutCtx.Done():
-		return 0, timeoutCtx.Err()
+	if uc.queue != nil {
+		uc.enqueue(dt)
+		return len(b), nil
 	}
 
-	return len(b), nil
-}
-
-func (uc *UniConn) randomLoss() bool {
-	if uc.loss > 0 {
-		l := rand.Float32()
-		if l < uc.loss {
-			uc.nLoss++
-			return true
+	if uc.nonBlocking {
+		atomic.AddInt64(&uc.nSendPacket, 1)
+		uc.mu.Lock()
+		uc.onEnqueue(dt)
+		now := time.Now()
+		if uc.linkReady(now) {
+			uc.transmit(dt, now, now)
+		} else {
+			atomic.AddInt64(&uc.nWriteDrop, 1)
+			uc.onDrop(dt, DropWrite)
+			putPacket(dt)
 		}
+		uc.mu.Unlock()
+		return len(b), nil
 	}
-	return false
-}
 
-// The routine to stimulate throughput by rate Limiter
-func (uc *UniConn) throughputRead() error {
-	defer close(uc.bufferCh)
+	defer func() {
+		if err != nil {
+			putPacket(dt)
+		}
+	}()
 
-	r := rate.NewLimiter(rate.Limit(uc.throughput), 1)
+	arrival := time.Now()
 	for {
-		err := r.Wait(uc.closeWriteCtx)
-		if err != nil {
-			return err
+		deadlineCtx := uc.writeDeadline.context()
+		if err = uc.writeDeadline.err(deadlineCtx); err != nil {
+			return 0, uc.ctxErr(err)
 		}
 
-		select {
-		case <-uc.closeWriteCtx.Done():
-			return uc.closeWriteCtx.Err()
-
-		case dt := <-uc.sendCh:
-			if dt != nil {
-				if !uc.randomLoss() {
-					dt.t = time.Now()
-					uc.bufferCh <- dt
-				}
-			}
+		uc.mu.Lock()
+		now := time.Now()
+		if uc.linkReady(now) {
+			uc.onEnqueue(dt)
+			uc.transmit(dt, arrival, now)
+			uc.mu.Unlock()
+			atomic.AddInt64(&uc.nSendPacket, 1)
+			return len(b), nil
 		}
-	}
-}
+		if now.Before(uc.nextSend) {
+			uc.wakeLinkAt(uc.nextSend)
+		} // else buffer is full, reader wakes us up
+		writable := uc.writable.wait()
+		uc.mu.Unlock()
 
-// The routine to stimulate latency
-func (uc *UniConn) latencyRead() error {
-	defer close(uc.recvCh)
-	for {
 		select {
+		case <-writable: // try again
+
 		case <-uc.closeReadCtx.Done():
-			return uc.closeReadCtx.Err()
-
-		case dt := <-uc.bufferCh:
-			if dt != nil {
-				dur := time.Since(dt.t)
-				if dur < uc.latency {
-					timer := time.NewTimer(uc.latency - dur)
-					select {
-					case <-uc.closeReadCtx.Done():
-						return uc.closeReadCtx.Err()
-
-					case <-timer.C:
-					}
-				}
-				uc.recvCh <- dt
-			}
+			return 0, ErrConnReset
+
+		case <-uc.scheduler.doneCh: // the link would never be ready
+			return 0, net.ErrClosed
+
+		case <-deadlineCtx.Done(): // deadline exceeded, write closed or deadline changed, check again
+
+		case <-timeoutCtx.Done():
+			return 0, uc.ctxErr(timeoutCtx.Err())
+
+		case <-ctx.Done():
+			return 0, ctx.Err()
 		}
 	}
 }
 
-func (uc *UniConn) Read(b []byte) (n int, err error) {
-	if err = uc.readCtx.Err(); err != nil {
-		return 0, err
+// Wrap the error in net.OpError like real sockets.
+func (uc *UniConn) opError(op string, err error) error {
+	if err == nil || err == io.EOF {
+		return err
 	}
 
-	// check buffered unread data
-	unreadLen := len(uc.unreadData)
-	if unreadLen > 0 {
-		if unreadLen <= len(b) {
-			copy(b, uc.unreadData)
-			uc.unreadData = make([]byte, 0)
-			return unreadLen, nil
-		} else {
-			copy(b, uc.unreadData[0:len(b)])
-			uc.unreadData = uc.unreadData[len(b):]
-			return len(b), nil
+	// reader is at the remote end of UniConn
+	source, addr := uc.LocalAddr(), uc.RemoteAddr()
+	if op == "read" {
+		source, addr = addr, source
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		uc.log(slog.LevelDebug, "deadline exceeded", "op", op)
+	}
+	return &net.OpError{Op: op, Net: ClientAddr{}.Network(), Source: source, Addr: addr, Err: err}
+}
+
+// The error to return when an internal deadline or close context is done, like real sockets it is
+// os.ErrDeadlineExceeded or net.ErrClosed, or ErrConnReset if the connection is aborted by Reset.
+func (uc *UniConn) ctxErr(err error) error {
+	if atomic.LoadInt32(&uc.reset) == 1 {
+		return ErrConnReset
+	}
+	switch err {
+	case context.DeadlineExceeded:
+		return os.ErrDeadlineExceeded
+	case context.Canceled:
+		return net.ErrClosed
+	}
+	return err
+}
+
+// Put the packet into the bottleneck queue without waiting for the link, the queue discipline may drop it.
+func (uc *UniConn) enqueue(dt *dataWithTime) {
+	atomic.AddInt64(&uc.nSendPacket, 1)
+
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	uc.onEnqueue(dt)
+	now := time.Now()
+	if !uc.queue.enqueue(dt, now) {
+		atomic.AddInt64(&uc.nQueueDrop, 1)
+		uc.onDrop(dt, DropQueue)
+		putPacket(dt)
+		return
+	}
+	uc.serveQueue(now)
+}
+
+// The link takes packets out of the bottleneck queue while it is ready. It keeps serving after write is closed
+// until the queue is drained. The caller should hold uc.mu.
+func (uc *UniConn) serveQueue(now time.Time) {
+	for uc.queue.len() > 0 && uc.closeReadCtx.Err() == nil {
+		if !uc.linkReady(now) {
+			if now.Before(uc.nextSend) {
+				uc.wakeLinkAt(uc.nextSend)
+			} // else buffer is full, reader serves the queue after taking a packet
+			return
+		}
+
+		dt, dropped := uc.queue.dequeue(now)
+		atomic.AddInt64(&uc.nQueueDrop, int64(len(dropped)))
+		for _, d := range dropped {
+			uc.onDrop(d, DropQueue)
+			putPacket(d)
 		}
+		if dt != nil {
+			uc.transmit(dt, dt.enqueued, now)
+		}
+	}
+}
+
+// linkReady tells if the link can send a packet now: the last packet has been sent and the buffer has room.
+// The buffer is unbounded if both throughput and BufferSize are unlimited.
+// The caller should hold uc.mu.
+func (uc *UniConn) linkReady(now time.Time) bool {
+	if uc.throughput == 0 && uc.defaultBuffer {
+		return true
+	}
+	return !now.Before(uc.nextSend) && uc.inFlight.len() < int(uc.bufferSize)
+}
+
+// updateBuffer sets the default buffer to 2 * throughput * latency packets, at least one packet, after they
+// change. The caller should hold uc.mu.
+func (uc *UniConn) updateBuffer() {
+	if !uc.defaultBuffer {
+		return
+	}
+	uc.bufferSize = uint(2 * float64(uc.throughput) * uc.latency.Seconds())
+	if uc.bufferSize == 0 && uc.throughput > 0 {
+		uc.bufferSize = 1
+	}
+}
+
+// The packet passes the link at now and can be read after latency unless it is random lost.
+// The caller should hold uc.mu.
+func (uc *UniConn) transmit(dt *dataWithTime, arrival, now time.Time) {
+	// The link sends the packet at nextSend or when it arrives if the link has been idle. A packet which
+	// has been waiting for the link, or arrives within maxLinkLag, is sent on the link clock even if it is
+	// handled late, so timer error and goroutine scheduling do not slow the link down.
+	if arrival.Sub(uc.nextSend) >= maxLinkLag {
+		uc.nextSend = arrival
+	}
+	uc.nextSend = uc.nextSend.Add(uc.interval)
+
+	if uc.blackhole || uc.partitioned {
+		uc.onDrop(dt, DropBlackhole)
+		putPacket(dt)
+		return
+	}
+	if uc.lossRate() > 0 || len(uc.interceptors) > 0 {
+		uc.intercept(dt, now)
+		return
+	}
+	uc.send(dt, now)
+}
+
+// send puts a packet passed the link in flight.
+func (uc *UniConn) send(dt *dataWithTime, now time.Time) {
+	uc.onSend(dt)
+
+	dt.t = now
+	dt.due = now.Add(uc.latency + uc.spikeLatency + dt.pkt.Delay)
+	uc.inFlight.push(dt)
+	if dt.due.After(now) {
+		uc.schedule(dt.due, uc.wakeReaderFunc)
+	} else {
+		uc.readable.broadcast()
+	}
+}
+
+// Schedule an event of uc at t, it is discarded after reading is closed so a closed connection leaves no event
+// running the scheduler. The caller should hold uc.mu.
+func (uc *UniConn) schedule(t time.Time, f func()) {
+	if uc.closeReadCtx.Err() == nil {
+		uc.scheduler.schedule(t, uc, f)
 	}
+}
+
+// Schedule an event to wake up the link at t if there is none. The caller should hold uc.mu.
+func (uc *UniConn) wakeLinkAt(t time.Time) {
+	if !uc.linkWake {
+		uc.linkWake = true
+		uc.schedule(t, uc.wakeLinkFunc)
+	}
+}
+
+// Event that the link is ready for the next packet.
+func (uc *UniConn) wakeLink() {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
 
-	var timeoutCtx context.Context
-	var timeoutCancel context.CancelFunc
-	if uc.readTimeout > 0 {
-		timeoutCtx, timeoutCancel = context.WithTimeout(uc.readCtx, uc.readTimeout)
+	uc.linkWake = false
+	if uc.queue != nil {
+		uc.serveQueue(time.Now())
 	} else {
-		timeoutCtx, timeoutCancel = context.WithCancel(uc.readCtx)
+		uc.writable.broadcast()
 	}
+}
+
+// Event that a packet has arrived after latency.
+func (uc *UniConn) wakeReader() {
+	uc.mu.Lock()
+	uc.readable.broadcast()
+	uc.mu.Unlock()
+}
+
+func (uc *UniConn) Read(b []byte) (n int, err error) {
+	return uc.ReadContext(context.Background(), b)
+}
+
+// ReadContext reads like Read, and returns ctx.Err() if ctx is done before data arrives.
+func (uc *UniConn) ReadContext(ctx context.Context, b []byte) (n int, err error) {
+	defer func() { err = uc.opError("read", err) }()
+
+	timeoutCtx, timeoutCancel := withTimeout(uc.readTimeout)
 	defer timeoutCancel()
 
 	for {
-		if err := uc.readCtx.Err(); err != nil {
+		if err = ctx.Err(); err != nil {
 			return 0, err
 		}
+		if atomic.LoadInt32(&uc.graceful) == 1 {
+			return 0, io.EOF
+		}
+		deadlineCtx := uc.readDeadline.context()
+		if err = uc.readDeadline.err(deadlineCtx); err != nil {
+			return 0, uc.ctxErr(err)
+		}
+
+		uc.mu.Lock()
+		if uc.readerGone {
+			uc.mu.Unlock()
+			return 0, net.ErrClosed
+		}
+		if n, ok := uc.read(b, time.Now()); ok {
+			uc.mu.Unlock()
+			return n, nil
+		}
+		// write is closed and all data is delivered
+		eof := uc.closeWriteCtx.Err() != nil && uc.inFlight.len() == 0 && (uc.queue == nil || uc.queue.len() == 0) &&
+			!uc.readFrozen && !uc.writerGone
+		readable := uc.readable.wait()
+		uc.mu.Unlock()
+
+		if eof {
+			return 0, io.EOF
+		}
 
 		select {
-		case dt := <-uc.recvCh:
-			if dt != nil {
-				if len(dt.data) > len(b) {
-					dt.data = dt.data[0:len(b)]
-					n = len(b)
-					uc.unreadData = dt.data[len(b):]
-				} else {
-					n = len(dt.data)
-				}
-
-				copy(b, dt.data)
-				uc.nRecvPacket++
-
-				uc.averageLatency = time.Duration(float64(uc.averageLatency)*(float64(uc.nRecvPacket-1)/float64(uc.nRecvPacket)) +
-					float64(time.Since(dt.t))/float64(uc.nRecvPacket))
-
-				return n, nil
-			}
+		case <-readable: // try again
+
+		case <-uc.scheduler.doneCh: // packets in flight would never arrive
+			return 0, net.ErrClosed
+
+		case <-deadlineCtx.Done(): // deadline exceeded, read closed or deadline changed, check again
 
 		case <-timeoutCtx.Done():
-			return 0, timeoutCtx.Err()
+			return 0, uc.ctxErr(timeoutCtx.Err())
+
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// Read from unread data or the first packet which has arrived, the part of packet not fit in b is saved
+// as unread data. Return false if there is nothing to read. The caller should hold uc.mu.
+func (uc *UniConn) read(b []byte, now time.Time) (int, bool) {
+	if uc.readFrozen {
+		return 0, false
+	}
+	if len(uc.unreadData) > 0 {
+		n := copy(b, uc.unreadData)
+		uc.unreadData = uc.unreadData[n:]
+		if len(uc.unreadData) == 0 {
+			uc.releaseUnread()
+			uc.taken.broadcast()
 		}
+		return n, true
+	}
+
+	if head := uc.inFlight.peek(); head == nil || head.due.After(now) {
+		return 0, false
+	}
+	if uc.drainRate > 0 && now.Before(uc.drainNext) {
+		uc.wakeDrainAt(uc.drainNext)
+		return 0, false
+	}
+	dt := uc.inFlight.pop()
+	uc.drain(dt, now)
+	uc.taken.broadcast()
+
+	// buffer has room for the link now
+	if uc.queue != nil {
+		uc.serveQueue(now)
+	} else {
+		uc.writable.broadcast()
+	}
+
+	nRecvPacket := atomic.AddInt64(&uc.nRecvPacket, 1)
+	uc.averageLatency = time.Duration(float64(uc.averageLatency)*(float64(nRecvPacket-1)/float64(nRecvPacket)) +
+		float64(now.Sub(dt.t))/float64(nRecvPacket))
+
+	uc.onDeliver(dt, now.Sub(dt.t))
+
+	n := copy(b, dt.data)
+	if n < len(dt.data) {
+		uc.unreadData, uc.unreadDt = dt.data[n:], dt
+	} else {
+		putPacket(dt)
+	}
+
+	return n, true
+}
+
+// Return the packet holding unread data to pool. The caller should hold uc.mu.
+func (uc *UniConn) releaseUnread() {
+	if uc.unreadDt != nil {
+		putPacket(uc.unreadDt)
 	}
+	uc.unreadData, uc.unreadDt = nil, nil
 }
 
+// CloseWrite stops writing, packets already written are still delivered before the reader gets io.EOF.
 func (uc *UniConn) CloseWrite() error {
 	uc.closeWriteCtxCancel()
-	close(uc.sendCh)
+
+	uc.mu.Lock()
+	uc.readable.broadcast()
+	uc.onClose()
+	uc.mu.Unlock()
 	return nil
 }
 
+// CloseRead stops reading, packets in flight and the pending events of the connection are discarded.
 func (uc *UniConn) CloseRead() error {
 	uc.closeReadCtxCancel()
+
+	uc.mu.Lock()
+	uc.scheduler.cancel(uc)
+	uc.linkWake, uc.drainWake = false, false
+	for dt := uc.inFlight.pop(); dt != nil; dt = uc.inFlight.pop() {
+		uc.onDrop(dt, DropClosed)
+		putPacket(dt)
+	}
+	for uc.queue != nil && uc.queue.len() > 0 {
+		dt, dropped := uc.queue.dequeue(time.Now())
+		for _, d := range append(dropped, dt) {
+			if d != nil {
+				uc.onDrop(d, DropClosed)
+				putPacket(d)
+			}
+		}
+	}
+	uc.releaseUnread()
+	uc.readable.broadcast()
+	uc.writable.broadcast()
+	uc.onClose()
+	uc.mu.Unlock()
 	return nil
 }
 
@@ -257,6 +575,52 @@ func (uc *UniConn) Close() error {
 	return nil
 }
 
+// CloseGracefully closes writing and waits until the packets written are read, like data before a TCP FIN,
+// then closes reading and later reads get io.EOF. If ctx is done first, the packets not read are discarded
+// and ctx.Err() is returned.
+func (uc *UniConn) CloseGracefully(ctx context.Context) error {
+	uc.CloseWrite()
+	err := uc.waitRead(ctx)
+	if err == nil && uc.closeReadCtx.Err() == nil {
+		atomic.StoreInt32(&uc.graceful, 1)
+	}
+	uc.CloseRead()
+	return err
+}
+
+// waitRead waits until the reader has read all packets written or reading is closed.
+func (uc *UniConn) waitRead(ctx context.Context) error {
+	for {
+		uc.mu.Lock()
+		done := uc.inFlight.len() == 0 && (uc.queue == nil || uc.queue.len() == 0) && len(uc.unreadData) == 0
+		taken := uc.taken.wait()
+		uc.mu.Unlock()
+		if done {
+			return nil
+		}
+
+		select {
+		case <-taken: // check again
+
+		case <-uc.closeReadCtx.Done():
+			return nil
+
+		case <-uc.scheduler.doneCh:
+			return net.ErrClosed
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Reset aborts the connection, packets in flight are discarded and both reader and writer get ErrConnReset.
+func (uc *UniConn) Reset() error {
+	atomic.StoreInt32(&uc.reset, 1)
+	uc.closeWriteCtxCancel()
+	return uc.CloseRead()
+}
+
 func (uc *UniConn) LocalAddr() net.Addr {
 	return ClientAddr{addr: uc.localAddr}
 }
@@ -277,27 +641,99 @@ func (uc *UniConn) SetDeadline(t time.Time) error {
 	return nil
 }
 
+// SetReadDeadline sets the deadline for Read, a Read waiting on the old deadline uses the new one.
 func (uc *UniConn) SetReadDeadline(t time.Time) error {
-	if t == zeroTime {
-		uc.readCtx, uc.readCancel = context.WithCancel(uc.closeReadCtx)
-	} else {
-		uc.readCtx, uc.readCancel = context.WithDeadline(uc.closeReadCtx, t)
-	}
+	uc.readDeadline.set(t)
 	return nil
 }
 
+// SetWriteDeadline sets the deadline for Write, a Write waiting on the old deadline uses the new one.
 func (uc *UniConn) SetWriteDeadline(t time.Time) error {
-	if t == zeroTime {
-		uc.writeCtx, uc.writeCancel = context.WithCancel(uc.closeWriteCtx)
-	} else {
-		uc.writeCtx, uc.writeCancel = context.WithDeadline(uc.closeWriteCtx, t)
-	}
+	uc.writeDeadline.set(t)
 	return nil
 }
 
+// Metrics of UniConn
+type Metrics struct {
+	SendPacket     int64         // number of packets sent
+	RecvPacket     int64         // number of packets received
+	Loss           int64         // number of packets are random lost
+	QueueDrop      int64         // number of packets are dropped by the bottleneck queue
+	WriteDrop      int64         // number of packets are dropped by non-blocking write
+	AverageLatency time.Duration // average latency of all packets
+}
+
+// Metrics returns a snapshot of the metrics, it is safe to call while reading and writing.
+func (uc *UniConn) Metrics() Metrics {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	return uc.metrics()
+}
+
+// metrics returns a snapshot of the metrics while mu is locked.
+func (uc *UniConn) metrics() Metrics {
+	return Metrics{
+		SendPacket:     atomic.LoadInt64(&uc.nSendPacket),
+		RecvPacket:     atomic.LoadInt64(&uc.nRecvPacket),
+		Loss:           atomic.LoadInt64(&uc.nLoss),
+		QueueDrop:      atomic.LoadInt64(&uc.nQueueDrop),
+		WriteDrop:      atomic.LoadInt64(&uc.nWriteDrop),
+		AverageLatency: uc.averageLatency,
+	}
+}
+
+// LogValue logs metrics as a group of attributes.
+func (m Metrics) LogValue() slog.Value {
+	return slog.GroupValue(slog.Int64("sendPacket", m.SendPacket), slog.Int64("recvPacket", m.RecvPacket),
+		slog.Int64("loss", m.Loss), slog.Int64("queueDrop", m.QueueDrop), slog.Int64("writeDrop", m.WriteDrop),
+		slog.Duration("averageLatency", m.AverageLatency))
+}
+
+// PrintMetrics logs metrics at info level to the Logger in ConnConfig, or to the default slog logger if it is not set.
 func (uc *UniConn) PrintMetrics() {
-	log.Printf("%v to %v, %v packets are sent, %v packets are received, %v packets are lost, average latency is %v, loss rate is %.3f\n",
-		uc.localAddr, uc.remoteAddr, uc.nSendPacket, uc.nRecvPacket, uc.nLoss, uc.averageLatency, float64(uc.nLoss)/float64(uc.nRecvPacket))
+	logger := uc.logger
+	if logger == nil {
+		logger = slog.Default().With("local", uc.localAddr, "remote", uc.remoteAddr)
+	}
+	logger.Info("metrics", "metrics", uc.Metrics())
+}
+
+// SetThroughput changes the throughput by packets/second, unlimited if zero.
+func (uc *UniConn) SetThroughput(throughput uint) {
+	uc.mu.Lock()
+	uc.throughput = throughput
+	uc.interval = 0
+	if throughput > 0 {
+		uc.interval = time.Second / time.Duration(throughput)
+	}
+	uc.updateBuffer()
+	uc.mu.Unlock()
+	uc.log(slog.LevelInfo, "config changed", "throughput", throughput)
+}
+
+// SetLatency changes the latency of packets sent afterwards.
+func (uc *UniConn) SetLatency(latency time.Duration) {
+	uc.mu.Lock()
+	uc.latency = latency
+	uc.updateBuffer()
+	uc.mu.Unlock()
+	uc.log(slog.LevelInfo, "config changed", "latency", latency)
+}
+
+// SetLoss changes the loss rate, 0.01 = 1%.
+func (uc *UniConn) SetLoss(loss float32) {
+	uc.mu.Lock()
+	uc.loss = loss
+	uc.mu.Unlock()
+	uc.log(slog.LevelInfo, "config changed", "loss", loss)
+}
+
+// log an event if Logger is set in ConnConfig and enabled at level.
+func (uc *UniConn) log(level slog.Level, msg string, args ...any) {
+	if uc.logger == nil || !uc.logger.Enabled(context.Background(), level) {
+		return
+	}
+	uc.logger.Log(context.Background(), level, msg, args...)
 }
 
 func (uc *UniConn) String() string {
@@ -1,8 +1,13 @@
 package mockconn
 
 import (
+	"bytes"
 	"context"
-	"fmt"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"os"
 	"testing"
 	"time"
 
@@ -20,11 +25,11 @@ func TestUniConnThroughput(t *testing.T) {
 		require.Nil(t, err)
 		require.NotNil(t, uc)
 
-		fmt.Println("target tp is", tp)
+		t.Log("target tp is", tp)
 		sendChan := make(chan []int64)
 		recvChan := make(chan []int64)
 		nPacket := 100
-		go WritePacktes(uc, nPacket, sendChan)
+		go WritePackets(uc, nPacket, sendChan)
 		go ReadPackets(uc, nPacket, conf.Latency, recvChan)
 
 		<-sendChan
@@ -32,6 +37,70 @@ func TestUniConnThroughput(t *testing.T) {
 	}
 }
 
+// go test -v -run=TestUnlimitedThroughput
+func TestUnlimitedThroughput(t *testing.T) {
+	// without Throughput and BufferSize, writes do not wait for packets in flight
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Latency: 10 * time.Millisecond}
+	uc, err := NewUniConn(conf)
+	require.Nil(t, err)
+
+	nPacket := 50
+	start := time.Now()
+	for i := 0; i < nPacket; i++ {
+		_, err = uc.Write([]byte("hello"))
+		require.Nil(t, err)
+	}
+	require.Less(t, time.Since(start), conf.Latency)
+
+	b := make([]byte, 1024)
+	for i := 0; i < nPacket; i++ {
+		_, err = uc.Read(b)
+		require.Nil(t, err)
+	}
+	require.Less(t, time.Since(start), 2*conf.Latency)
+	uc.Close()
+}
+
+// go test -v -run=TestSetThroughputDefaultBuffer
+func TestSetThroughputDefaultBuffer(t *testing.T) {
+	// the default buffer follows throughput set later, writes do not wait for each packet to arrive
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Latency: 50 * time.Millisecond}
+	uc, err := NewUniConn(conf)
+	require.Nil(t, err)
+	defer uc.Close()
+	uc.SetThroughput(1000)
+
+	nPacket := 20
+	start := time.Now()
+	for i := 0; i < nPacket; i++ {
+		_, err = uc.Write([]byte("hello"))
+		require.Nil(t, err)
+	}
+	require.Less(t, time.Since(start), conf.Latency)
+
+	b := make([]byte, 1024)
+	for i := 0; i < nPacket; i++ {
+		_, err = uc.Read(b)
+		require.Nil(t, err)
+	}
+	require.Less(t, time.Since(start), 3*conf.Latency)
+}
+
+// go test -v -run=TestBufferSize
+func TestBufferSize(t *testing.T) {
+	// at most BufferSize packets are in flight
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Latency: time.Second, BufferSize: 3, NonBlocking: true}
+	uc, err := NewUniConn(conf)
+	require.Nil(t, err)
+	defer uc.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err = uc.Write([]byte("hello"))
+		require.Nil(t, err)
+	}
+	require.Equal(t, int64(2), uc.Metrics().WriteDrop)
+}
+
 // go test -v -run=TestSetReadDeadline
 func TestSetReadDeadline(t *testing.T) {
 	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(16), Latency: 100 * time.Millisecond}
@@ -42,9 +111,15 @@ func TestSetReadDeadline(t *testing.T) {
 	uc.SetReadDeadline(time.Now().Add(time.Second))
 	b := make([]byte, 1024)
 	n, err := uc.Read(b)
-	require.NotNil(t, err)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
 	require.Equal(t, 0, n)
 
+	// deadline stays expired until it is reset
+	n, err = uc.Read(b)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.Equal(t, 0, n)
+	uc.SetReadDeadline(zeroTime)
+
 	n, err = uc.Write(b)
 	require.Nil(t, err)
 	require.Equal(t, 1024, n)
@@ -74,15 +149,21 @@ func TestClose(t *testing.T) {
 	uc.Write(b)
 	uc.CloseRead()
 	n, err = uc.Read(b2)
-	require.NotNil(t, err)
+	require.ErrorIs(t, err, net.ErrClosed)
 	require.Equal(t, 0, n)
 	t.Log("After close read, read err ", err)
 
 	uc.CloseWrite()
 	n, err = uc.Write(b)
-	require.NotNil(t, err)
+	require.ErrorIs(t, err, net.ErrClosed)
 	require.Equal(t, 0, n)
 	t.Log("After close write, write err ", err)
+
+	var opErr *net.OpError
+	require.ErrorAs(t, err, &opErr)
+	require.Equal(t, "write", opErr.Op)
+	require.Equal(t, "Alice", opErr.Source.String())
+	require.Equal(t, "Bob", opErr.Addr.String())
 }
 
 // go test -v -run=TestRateLimiter
@@ -97,7 +178,7 @@ func TestRateLimiter(t *testing.T) {
 	}
 	d := time.Since(start)
 
-	fmt.Printf("Count %v took %v, average is %.1f, expected is %v\n",
+	t.Logf("Count %v took %v, average is %.1f, expected is %v",
 		count, d, float64(count)/d.Seconds(), lim)
 }
 
@@ -162,7 +243,10 @@ func TestTimeout(t *testing.T) {
 		n, err := uc.Write(b)
 		if err != nil {
 			require.Equal(t, 0, n)
-			require.Equal(t, context.DeadlineExceeded, err)
+			require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+			var ne net.Error
+			require.ErrorAs(t, err, &ne)
+			require.True(t, ne.Timeout())
 			break
 		}
 	}
@@ -172,8 +256,130 @@ func TestTimeout(t *testing.T) {
 		n, err := uc.Read(b)
 		if err != nil {
 			require.Equal(t, 0, n)
-			require.Equal(t, context.DeadlineExceeded, err)
+			require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+			var ne net.Error
+			require.ErrorAs(t, err, &ne)
+			require.True(t, ne.Timeout())
 			break
 		}
 	}
 }
+
+// go test -v -run=TestNonBlockingWrite
+func TestNonBlockingWrite(t *testing.T) {
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(100), Latency: 20 * time.Millisecond, NonBlocking: true}
+	uc, err := NewUniConn(conf)
+	require.Nil(t, err)
+	require.NotNil(t, uc)
+
+	// write twice as fast as the link, writer is not held back and about half of packets are dropped
+	b := make([]byte, 1024)
+	for i := 0; i < 100; i++ {
+		start := time.Now()
+		n, err := uc.Write(b)
+		require.Nil(t, err)
+		require.Equal(t, len(b), n)
+		require.Less(t, time.Since(start), 5*time.Millisecond)
+		time.Sleep(5 * time.Millisecond)
+	}
+	m := uc.Metrics()
+	require.Equal(t, int64(100), m.SendPacket)
+	require.Greater(t, m.WriteDrop, int64(20))
+	require.Less(t, m.WriteDrop, int64(100))
+
+	uc.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := uc.Read(b)
+	require.Nil(t, err)
+	require.Equal(t, len(b), n)
+	uc.Close()
+}
+
+// go test -v -run=TestLogger
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", BufferSize: 10, Interceptors: []Interceptor{RandomLoss(1)},
+		Logger: logger}
+	uc, err := NewUniConn(conf)
+	require.Nil(t, err)
+
+	uc.SetLatency(time.Millisecond)
+	_, err = uc.Write([]byte("hello"))
+	require.Nil(t, err)
+	uc.SetReadDeadline(time.Now())
+	_, err = uc.Read(make([]byte, 10))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	uc.Close()
+
+	var msgs []string
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var event map[string]interface{}
+		require.Nil(t, json.Unmarshal(line, &event))
+		require.Equal(t, "Alice", event["local"])
+		require.Equal(t, "Bob", event["remote"])
+		msgs = append(msgs, event["msg"].(string))
+	}
+	require.Equal(t, []string{"connection opened", "config changed", "packet dropped", "deadline exceeded",
+		"connection closed"}, msgs)
+}
+
+// go test -v -run=TestSetConfig
+func TestSetConfig(t *testing.T) {
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(100), BufferSize: 100}
+	aliceConn, bobConn, err := NewMockConn(conf)
+	require.Nil(t, err)
+	alice, bob := aliceConn.(*NetConn), bobConn.(*NetConn)
+
+	alice.SetThroughput(0)
+	alice.SetLatency(50 * time.Millisecond)
+	start := time.Now()
+	b := make([]byte, 1024)
+	for i := 0; i < 10; i++ {
+		_, err = alice.Write(b)
+		require.Nil(t, err)
+	}
+	require.Less(t, time.Since(start), 50*time.Millisecond) // unlimited throughput
+	_, err = bob.Read(b)
+	require.Nil(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	alice.SetLoss(1)
+	_, err = alice.Write(b)
+	require.Nil(t, err)
+	require.Equal(t, int64(1), bob.Metrics().Loss)
+
+	alice.Close()
+	bob.Close()
+}
+
+// go test -v -run=TestCloseGracefully
+func TestCloseGracefully(t *testing.T) {
+	conf := &ConnConfig{Addr1: "Alice", Addr2: "Bob", Throughput: uint(100), Latency: 50 * time.Millisecond}
+	uc, err := NewUniConn(conf)
+	require.Nil(t, err)
+
+	for i := 0; i < 5; i++ {
+		_, err = uc.Write([]byte("hello"))
+		require.Nil(t, err)
+	}
+
+	// the reader gets every packet and then io.EOF
+	readCh := make(chan int)
+	go func() {
+		n, _ := io.Copy(io.Discard, uc)
+		readCh <- int(n)
+	}()
+	require.Nil(t, uc.CloseGracefully(context.Background()))
+	require.Equal(t, 25, <-readCh)
+
+	// packets not read in time are discarded
+	uc, err = NewUniConn(conf)
+	require.Nil(t, err)
+	_, err = uc.Write([]byte("hello"))
+	require.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, uc.CloseGracefully(ctx), context.DeadlineExceeded)
+	_, err = uc.Read(make([]byte, 1024))
+	require.ErrorIs(t, err, net.ErrClosed)
+}